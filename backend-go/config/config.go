@@ -13,13 +13,26 @@ import (
 )
 
 type Cfg struct {
-	Host        string `json:"host"`
-	Port        string `json:"port"`
-	Password    string `json:"password"`
-	SafeBaseDir string `json:"safeBaseDir"`
-	EnableLog   bool   `json:"enableLog"`
-	SSLKey      string `json:"sslKey"`
-	SSLCert     string `json:"sslCert"`
+	Host            string `json:"host"`
+	Port            string `json:"port"`
+	Password        string `json:"password"`
+	SafeBaseDir     string `json:"safeBaseDir"`
+	EnableLog       bool   `json:"enableLog"`
+	SSLKey          string `json:"sslKey"`
+	SSLCert         string `json:"sslCert"`
+	WebDAVPrefix    string `json:"webdavPrefix"`
+	SymlinkPolicy   string `json:"symlinkPolicy"`
+	Users           []User `json:"users"`
+	TrashMaxAgeDays int    `json:"trashMaxAgeDays"`
+	TrashMaxSizeMB  int64  `json:"trashMaxSizeMB"`
+
+	ContentIndex            bool  `json:"contentIndex"`
+	ContentIndexMaxFileKB   int64 `json:"contentIndexMaxFileKB"`
+	ContentIndexIntervalMin int   `json:"contentIndexIntervalMin"`
+
+	VersionMaxCount   int   `json:"versionMaxCount"`
+	VersionMaxAgeDays int   `json:"versionMaxAgeDays"`
+	VersionMaxFileMB  int64 `json:"versionMaxFileMB"`
 }
 
 const PkgName = "file-lite-go"
@@ -63,13 +76,26 @@ func LoadConfig(allowCreate bool) {
 	}
 
 	def := Cfg{
-		Host:        "",
-		Port:        "",
-		Password:    "",
-		SafeBaseDir: "./",
-		EnableLog:   true,
-		SSLKey:      "",
-		SSLCert:     "",
+		Host:            "",
+		Port:            "",
+		Password:        "",
+		SafeBaseDir:     "./",
+		EnableLog:       true,
+		SSLKey:          "",
+		SSLCert:         "",
+		WebDAVPrefix:    "",
+		SymlinkPolicy:   SymlinkFollowWithinSandbox,
+		Users:           []User{},
+		TrashMaxAgeDays: 30,
+		TrashMaxSizeMB:  10240,
+
+		ContentIndex:            false,
+		ContentIndexMaxFileKB:   1024,
+		ContentIndexIntervalMin: 10,
+
+		VersionMaxCount:   20,
+		VersionMaxAgeDays: 30,
+		VersionMaxFileMB:  100,
 	}
 	fp := filepath.Join(dataBaseDir, "config.json")
 	configFilePath = fp
@@ -86,17 +112,13 @@ func LoadConfig(allowCreate bool) {
 		}
 	} else {
 		b, _ := os.ReadFile(fp)
+		cfg.Users = nil
 		_ = json.Unmarshal(b, &cfg)
 		configInitialized = true
 	}
 
 	if cfg.SafeBaseDir != "" {
-		wd, _ := os.Getwd()
-		abs := normalizePath(filepath.Clean(filepath.Join(wd, cfg.SafeBaseDir)))
-		if filepath.IsAbs(cfg.SafeBaseDir) {
-			abs = normalizePath(filepath.Clean(cfg.SafeBaseDir))
-		}
-		safeBaseDir = abs
+		safeBaseDir = resolveDir(cfg.SafeBaseDir)
 		if safeBaseDir != "" {
 			if allowCreate {
 				if _, err := os.Stat(safeBaseDir); err != nil {
@@ -108,6 +130,7 @@ func LoadConfig(allowCreate bool) {
 	} else {
 		safeBaseDir = ""
 	}
+	loadUsers(allowCreate)
 
 	if cfg.Password != "" {
 		authToken = cfg.Password
@@ -151,6 +174,31 @@ func Host() string {
 
 func IsHTTPS() bool { return cfg.SSLKey != "" && cfg.SSLCert != "" }
 
+func WebDAVPrefix() string {
+	p := strings.Trim(normalizePath(cfg.WebDAVPrefix), "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+const (
+	SymlinkFollow              = "follow"
+	SymlinkFollowWithinSandbox = "follow-within-sandbox"
+	SymlinkDeny                = "deny"
+)
+
+// SymlinkPolicy decides how sandbox checks treat symlinks: "follow" trusts
+// them blindly, "follow-within-sandbox" (the default) allows those that
+// resolve inside the sandbox, "deny" refuses any path that goes through one.
+func SymlinkPolicy() string {
+	switch cfg.SymlinkPolicy {
+	case SymlinkFollow, SymlinkDeny:
+		return cfg.SymlinkPolicy
+	}
+	return SymlinkFollowWithinSandbox
+}
+
 func AuthParam() string {
 	return "auth=" + authToken
 }
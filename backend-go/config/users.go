@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+const (
+	PermRead        = "read"
+	PermUpload      = "upload"
+	PermRename      = "rename"
+	PermDelete      = "delete"
+	PermDownloadZip = "downloadZip"
+)
+
+type Permissions struct {
+	Read        bool `json:"read"`
+	Upload      bool `json:"upload"`
+	Rename      bool `json:"rename"`
+	Delete      bool `json:"delete"`
+	DownloadZip bool `json:"downloadZip"`
+}
+
+func (p Permissions) Allows(name string) bool {
+	switch name {
+	case PermRead:
+		return p.Read
+	case PermUpload:
+		return p.Upload
+	case PermRename:
+		return p.Rename
+	case PermDelete:
+		return p.Delete
+	case PermDownloadZip:
+		return p.DownloadZip
+	}
+	return false
+}
+
+// User is an account from the "users" section of config.json. Root replaces
+// safeBaseDir for that user; an empty Root falls back to safeBaseDir.
+// PasswordHash is a bcrypt hash.
+type User struct {
+	Username     string      `json:"username"`
+	PasswordHash string      `json:"passwordHash"`
+	Root         string      `json:"root"`
+	Permissions  Permissions `json:"permissions"`
+	// Admin is set for the master password only, never read from config.json.
+	Admin bool `json:"-"`
+}
+
+var users []User
+
+func Users() []User { return users }
+
+func FindUser(name string) *User {
+	for i := range users {
+		if users[i].Username == name {
+			return &users[i]
+		}
+	}
+	return nil
+}
+
+// AdminUser is the identity behind the master password: the global sandbox and every permission.
+func AdminUser() User {
+	return User{
+		Root:        safeBaseDir,
+		Permissions: Permissions{Read: true, Upload: true, Rename: true, Delete: true, DownloadZip: true},
+		Admin:       true,
+	}
+}
+
+func resolveDir(p string) string {
+	if filepath.IsAbs(p) {
+		return normalizePath(filepath.Clean(p))
+	}
+	wd, _ := os.Getwd()
+	return normalizePath(filepath.Clean(filepath.Join(wd, p)))
+}
+
+func loadUsers(allowCreate bool) {
+	users = nil
+	for _, u := range cfg.Users {
+		if u.Username == "" || u.PasswordHash == "" {
+			fmt.Printf("user ignored: username and passwordHash are required\n")
+			continue
+		}
+		if u.Root == "" {
+			u.Root = safeBaseDir
+		} else {
+			u.Root = resolveDir(u.Root)
+			if allowCreate {
+				if _, err := os.Stat(u.Root); err != nil {
+					_ = os.MkdirAll(u.Root, fs.ModePerm)
+				}
+			}
+		}
+		u.Admin = false
+		users = append(users, u)
+		fmt.Printf("user: %s (root: %s)\n", u.Username, u.Root)
+	}
+}
@@ -40,7 +40,11 @@ var (
 func frontendStaticMiddleware(staticFS http.FileSystem) echo.MiddlewareFunc {
 	return middleware.StaticWithConfig(middleware.StaticConfig{
 		Skipper: func(c echo.Context) bool {
-			return strings.HasPrefix(c.Request().URL.Path, "/api")
+			p := c.Request().URL.Path
+			if dav := config.WebDAVPrefix(); dav != "" && (p == dav || strings.HasPrefix(p, dav+"/")) {
+				return true
+			}
+			return strings.HasPrefix(p, "/api")
 		},
 		Root:       ".",
 		Index:      "index.html",
@@ -85,6 +89,7 @@ func startServer() (*StartServerResult, error) {
 	api := e.Group("/api")
 	api.Use(middlewares.RateLimiter())
 	routes.Register(api)
+	routes.RegisterWebDAV(e)
 
 	port := config.Port()
 	host := config.Host()
@@ -239,7 +244,7 @@ func main() {
 						} else {
 							opts = append(opts, "✨ Create config file")
 						}
-						opts = append(opts, "🔄 Restart server", "🚪 Exit")
+						opts = append(opts, "🔑 Hash user password", "🔄 Restart server", "🚪 Exit")
 						return opts
 					}(),
 				},
@@ -269,6 +274,17 @@ func main() {
 		case strings.Contains(answers.Action, "Create config file"):
 			stopServer()
 			isCreateConfig = true
+		case strings.Contains(answers.Action, "Hash user password"):
+			password := ""
+			if err := survey.AskOne(&survey.Password{Message: "Password:"}, &password); err != nil || password == "" {
+				break
+			}
+			hash, err := middlewares.HashPassword(password)
+			if err != nil {
+				fmt.Println(err.Error())
+				break
+			}
+			fmt.Printf("passwordHash: %s\n\n", hash)
 		case strings.Contains(answers.Action, "Restart server"):
 			fmt.Print("\033[H\033[2J")
 			stopServer()
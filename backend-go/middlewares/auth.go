@@ -104,6 +104,17 @@ func (l *ipLimiter) cleanupExpired(now time.Time) {
 
 var authLimiter = newIPLimiter()
 
+// CheckBanned, RecordAuthFailure and RecordAuthSuccess let handlers that verify
+// their own secrets (such as share passwords) share the login brute-force limiter.
+func CheckBanned(c echo.Context) bool {
+	banned, _ := authLimiter.check(c.RealIP())
+	return banned
+}
+
+func RecordAuthFailure(c echo.Context) { authLimiter.recordFailure(c.RealIP()) }
+
+func RecordAuthSuccess(c echo.Context) { authLimiter.recordSuccess(c.RealIP()) }
+
 // authTokenCookieName must match frontend AUTH_TOKEN_COOKIE_KEY.
 const authTokenCookieName = "file_lite_auth_token"
 const csrfHeaderName = "X-File-Lite-CSRF"
@@ -145,16 +156,48 @@ func AuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 				token = ck.Value
 			}
 		}
-		if token == config.AuthToken() {
+		if user, ok := resolveToken(token); ok {
 			if !config.IsExplicitDevMode() && fromHeader == "" && !isSafeMethod(c.Request().Method) {
 				if csrfToken := c.Request().Header.Get(csrfHeaderName); csrfToken == "" || csrfToken != fromCookie {
 					return c.JSON(http.StatusForbidden, map[string]string{"message": "Forbidden"})
 				}
 			}
 			authLimiter.recordSuccess(ip)
+			c.Set(userContextKey, user)
 			return next(c)
 		}
 		authLimiter.recordFailure(ip)
 		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
 	}
 }
+
+// BasicAuthMiddleware accepts the same credentials as AuthMiddleware carried as
+// HTTP Basic, for clients such as WebDAV mounts that cannot send custom headers:
+// any user name with the master token, or a configured user's name and password.
+func BasicAuthMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		ip := c.RealIP()
+		banned, _ := authLimiter.check(ip)
+		if banned {
+			return c.JSON(http.StatusForbidden, map[string]any{"message": "Forbidden"})
+		}
+		username, password, ok := c.Request().BasicAuth()
+		if ok {
+			token := password
+			if password != config.AuthToken() {
+				token = username + ":" + password
+			}
+			if user, valid := resolveToken(token); valid {
+				authLimiter.recordSuccess(ip)
+				c.Set(userContextKey, user)
+				return next(c)
+			}
+		}
+		// Clients probe without credentials first; only wrong credentials count as failures.
+		if ok {
+			authLimiter.recordFailure(ip)
+		}
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="file-lite"`)
+		return c.JSON(http.StatusUnauthorized, map[string]string{"message": "Unauthorized"})
+	}
+}
@@ -18,6 +18,7 @@ var skipPaths = map[string]bool{
 	"/api/files/stream":      true,
 	"/api/files/download":    true,
 	"/api/files/upload-file": true,
+	"/api/files/thumbnail":   true,
 }
 
 type counter struct {
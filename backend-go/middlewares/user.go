@@ -0,0 +1,74 @@
+package middlewares
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"file-lite-go/config"
+)
+
+const userContextKey = "user"
+
+// verifiedTokens caches successful bcrypt checks so that each request does not
+// pay for a hash comparison. The key is a SHA-256 of the stored hash and the
+// password, so changing a password in config.json invalidates the entry and
+// no plaintext password stays in memory.
+var verifiedTokens sync.Map
+
+// resolveToken maps an auth token to an account: the master password yields the
+// admin user, "username:password" yields a configured user.
+func resolveToken(token string) (config.User, bool) {
+	if token == "" {
+		return config.User{}, false
+	}
+	if token == config.AuthToken() {
+		return config.AdminUser(), true
+	}
+	name, password, ok := strings.Cut(token, ":")
+	if !ok {
+		return config.User{}, false
+	}
+	u := config.FindUser(name)
+	if u == nil {
+		return config.User{}, false
+	}
+	key := sha256.Sum256([]byte(u.PasswordHash + "\x00" + password))
+	if _, ok := verifiedTokens.Load(key); ok {
+		return *u, true
+	}
+	if bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return config.User{}, false
+	}
+	verifiedTokens.Store(key, struct{}{})
+	return *u, true
+}
+
+// CurrentUser returns the account resolved by AuthMiddleware. Requests that did
+// not pass through it get the global sandbox and no permissions.
+func CurrentUser(c echo.Context) config.User {
+	if u, ok := c.Get(userContextKey).(config.User); ok {
+		return u
+	}
+	return config.User{Root: config.SafeBaseDir()}
+}
+
+func RequirePermission(perm string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !CurrentUser(c).Permissions.Allows(perm) {
+				return c.JSON(http.StatusForbidden, map[string]string{"message": "Permission denied: " + perm})
+			}
+			return next(c)
+		}
+	}
+}
+
+func HashPassword(password string) (string, error) {
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(b), err
+}
@@ -0,0 +1,251 @@
+package routes
+
+import (
+	"io/fs"
+	"net/http"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/middlewares"
+)
+
+// attrResult is one entry of a chmod/chown/touch response.
+type attrResult struct {
+	Path    string `json:"path"`
+	Skipped bool   `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+func registerAttrs(g *echo.Group, upload echo.MiddlewareFunc) {
+	g.POST("/chmod", func(c echo.Context) error { return chmodPaths(c) }, upload)
+	g.POST("/chown", func(c echo.Context) error { return chownPaths(c) }, upload)
+	g.POST("/touch", func(c echo.Context) error { return touchPaths(c) }, upload)
+}
+
+// applyAttrs calls fn for every path and, when recursive, for everything below
+// it. Symlinks found during the walk are not followed; fn gets their Lstat and
+// decides for itself. Top level paths have already passed isPathSafe.
+func applyAttrs(paths []string, recursive bool, fn func(p string, st os.FileInfo) (bool, error)) []attrResult {
+	results := []attrResult{}
+	record := func(p string, skipped bool, err error) {
+		r := attrResult{Path: p, Skipped: skipped}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		results = append(results, r)
+	}
+	for _, root := range paths {
+		st, err := os.Stat(root)
+		if err != nil {
+			record(root, false, err)
+			continue
+		}
+		if !recursive || !st.IsDir() {
+			skipped, err := fn(root, st)
+			record(root, skipped, err)
+			continue
+		}
+		_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				record(p, false, err)
+				return nil
+			}
+			var st os.FileInfo
+			if p == root {
+				st, err = os.Stat(p)
+			} else {
+				st, err = d.Info()
+			}
+			if err != nil {
+				record(p, false, err)
+				return nil
+			}
+			skipped, err := fn(p, st)
+			record(p, skipped, err)
+			return nil
+		})
+	}
+	return results
+}
+
+// checkAttrPaths returns why paths cannot be changed, or "" if they can.
+func checkAttrPaths(c echo.Context, paths []string) string {
+	if len(paths) == 0 {
+		return "paths is required"
+	}
+	for _, p := range paths {
+		if !isPathSafe(c, p) {
+			return "Path is not safe: " + p
+		}
+	}
+	return ""
+}
+
+// parseFileMode reads an octal mode such as "755" or "0644". The setuid,
+// setgid and sticky bits are mapped onto their os.FileMode flags.
+func parseFileMode(s string) (os.FileMode, error) {
+	n, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || n > 0o7777 {
+		return 0, fmtError("Invalid mode: %s", s)
+	}
+	m := os.FileMode(n) & os.ModePerm
+	if n&0o4000 != 0 {
+		m |= os.ModeSetuid
+	}
+	if n&0o2000 != 0 {
+		m |= os.ModeSetgid
+	}
+	if n&0o1000 != 0 {
+		m |= os.ModeSticky
+	}
+	return m, nil
+}
+
+// chmodPaths sets mode on paths; with recursive, dirMode (defaulting to mode)
+// applies to directories below them. Symlinks inside the tree are skipped,
+// since chmod would change whatever they point to.
+func chmodPaths(c echo.Context) error {
+	var body struct {
+		Paths     []string `json:"paths"`
+		Mode      string   `json:"mode"`
+		DirMode   string   `json:"dirMode"`
+		Recursive bool     `json:"recursive"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if msg := checkAttrPaths(c, body.Paths); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+	mode, err := parseFileMode(body.Mode)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	dirMode := mode
+	if body.DirMode != "" {
+		if dirMode, err = parseFileMode(body.DirMode); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+	}
+	// With the server running as root a setuid bit would hand out its privileges.
+	special := os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+	if (mode|dirMode)&special != 0 && !middlewares.CurrentUser(c).Admin {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "Only admins may set setuid, setgid or sticky bits"})
+	}
+	results := applyAttrs(body.Paths, body.Recursive, func(p string, st os.FileInfo) (bool, error) {
+		if st.Mode()&os.ModeSymlink != 0 {
+			return true, nil
+		}
+		m := mode
+		if st.IsDir() {
+			m = dirMode
+		}
+		return false, os.Chmod(p, m)
+	})
+	return c.JSON(http.StatusOK, results)
+}
+
+// lookupID resolves a numeric id or a user/group name; "" means unchanged (-1).
+func lookupID(s string, lookup func(string) (string, error)) (int, error) {
+	if s == "" {
+		return -1, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return n, nil
+	}
+	id, err := lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(id)
+}
+
+// chownPaths changes owner and/or group. Only the server running as root can
+// give files away, and only admins may ask it to.
+func chownPaths(c echo.Context) error {
+	var body struct {
+		Paths     []string `json:"paths"`
+		Owner     string   `json:"owner"`
+		Group     string   `json:"group"`
+		Recursive bool     `json:"recursive"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if !middlewares.CurrentUser(c).Admin {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "Only admins may change ownership"})
+	}
+	if os.Geteuid() != 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Changing ownership requires the server to run as root"})
+	}
+	if msg := checkAttrPaths(c, body.Paths); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+	if body.Owner == "" && body.Group == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "owner or group is required"})
+	}
+	uid, err := lookupID(body.Owner, func(s string) (string, error) {
+		u, err := user.Lookup(s)
+		if err != nil {
+			return "", err
+		}
+		return u.Uid, nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unknown owner: " + body.Owner})
+	}
+	gid, err := lookupID(body.Group, func(s string) (string, error) {
+		g, err := user.LookupGroup(s)
+		if err != nil {
+			return "", err
+		}
+		return g.Gid, nil
+	})
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unknown group: " + body.Group})
+	}
+	results := applyAttrs(body.Paths, body.Recursive, func(p string, st os.FileInfo) (bool, error) {
+		if st.Mode()&os.ModeSymlink != 0 {
+			return false, os.Lchown(p, uid, gid)
+		}
+		return false, os.Chown(p, uid, gid)
+	})
+	return c.JSON(http.StatusOK, results)
+}
+
+// touchPaths sets modification and access times (milliseconds); either one
+// left out defaults to now, like touch(1). Missing paths are not created.
+func touchPaths(c echo.Context) error {
+	var body struct {
+		Paths     []string `json:"paths"`
+		Mtime     *int64   `json:"mtime"`
+		Atime     *int64   `json:"atime"`
+		Recursive bool     `json:"recursive"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if msg := checkAttrPaths(c, body.Paths); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": msg})
+	}
+	now := time.Now()
+	mtime, atime := now, now
+	if body.Mtime != nil {
+		mtime = time.UnixMilli(*body.Mtime)
+	}
+	if body.Atime != nil {
+		atime = time.UnixMilli(*body.Atime)
+	}
+	results := applyAttrs(body.Paths, body.Recursive, func(p string, st os.FileInfo) (bool, error) {
+		if st.Mode()&os.ModeSymlink != 0 {
+			return true, nil
+		}
+		return false, os.Chtimes(p, atime, mtime)
+	})
+	return c.JSON(http.StatusOK, results)
+}
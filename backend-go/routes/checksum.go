@@ -0,0 +1,150 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/utils"
+)
+
+// checksumCacheMax bounds the remembered digests; the cache is dropped when
+// it fills up.
+const checksumCacheMax = 10000
+
+// checksumCache maps path, size, mtime and algorithm to a hex digest, so an
+// unchanged file is never hashed twice.
+var checksumCache = struct {
+	sync.Mutex
+	m map[string]string
+}{m: map[string]string{}}
+
+func checksumKey(p string, st os.FileInfo, algo string) string {
+	return p + "\x00" + strconv.FormatInt(st.Size(), 10) + "\x00" + strconv.FormatInt(st.ModTime().UnixNano(), 10) + "\x00" + algo
+}
+
+func cacheChecksums(p string, st os.FileInfo, sums map[string]string) {
+	checksumCache.Lock()
+	defer checksumCache.Unlock()
+	if len(checksumCache.m)+len(sums) > checksumCacheMax {
+		checksumCache.m = map[string]string{}
+	}
+	for algo, sum := range sums {
+		checksumCache.m[checksumKey(p, st, algo)] = sum
+	}
+}
+
+// getChecksum returns digests of a file; algo is a comma separated list
+// (default sha256) of md5, sha1, sha256, sha512, blake2b (BLAKE2b-256) and
+// crc32. Hashing stops when the client goes away.
+func getChecksum(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+	}
+	if !st.Mode().IsRegular() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a file"})
+	}
+	algos := []string{"sha256"}
+	if s := c.QueryParam("algo"); s != "" {
+		algos = strings.Split(strings.ToLower(s), ",")
+	}
+	if _, err := utils.NewChecksummer(algos); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+
+	sums := map[string]string{}
+	var missing []string
+	checksumCache.Lock()
+	for _, a := range algos {
+		if sum, ok := checksumCache.m[checksumKey(path, st, a)]; ok {
+			sums[a] = sum
+		} else {
+			missing = append(missing, a)
+		}
+	}
+	checksumCache.Unlock()
+	if len(missing) > 0 {
+		computed, err := utils.ChecksumFile(c.Request().Context(), path, missing)
+		if err != nil {
+			if c.Request().Context().Err() != nil {
+				return nil
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		}
+		// Only trust the result if the file did not change while it was read.
+		if after, err := os.Stat(path); err == nil && after.Size() == st.Size() && after.ModTime().Equal(st.ModTime()) {
+			cacheChecksums(path, st, computed)
+		}
+		for a, sum := range computed {
+			sums[a] = sum
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"path":         path,
+		"size":         st.Size(),
+		"lastModified": st.ModTime().UnixMilli(),
+		"checksums":    sums,
+	})
+}
+
+// checksumMismatchPolicy validates the checksumMismatch option of uploads:
+// "reject" (default) discards the upload, "flag" keeps it and reports the mismatch.
+func checksumMismatchPolicy(s string) (string, error) {
+	switch s {
+	case "", "reject":
+		return "reject", nil
+	case "flag":
+		return "flag", nil
+	}
+	return "", fmtError("Unknown checksumMismatch policy: %s", s)
+}
+
+// uploadChecksumResult is added to upload responses when a checksum was given.
+type uploadChecksumResult struct {
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Mismatch bool   `json:"checksumMismatch"`
+}
+
+// uploadChecksum hashes upload data as it is written and compares it with
+// the expected "algo:hex" checksum.
+type uploadChecksum struct {
+	algo string
+	want string
+	cs   *utils.Checksummer
+}
+
+func newUploadChecksum(expected string) (*uploadChecksum, error) {
+	algo, want, err := utils.ParseChecksum(expected)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := utils.NewChecksummer([]string{algo})
+	if err != nil {
+		return nil, err
+	}
+	return &uploadChecksum{algo: algo, want: want, cs: cs}, nil
+}
+
+func (u *uploadChecksum) Write(p []byte) (int, error) { return u.cs.Write(p) }
+
+func (u *uploadChecksum) result() *uploadChecksumResult {
+	got := u.cs.Sums()[u.algo]
+	return &uploadChecksumResult{Expected: u.algo + ":" + u.want, Actual: u.algo + ":" + got, Mismatch: got != u.want}
+}
+
+// remember caches the digest for the file now stored at p.
+func (u *uploadChecksum) remember(p string) {
+	if st, err := os.Stat(p); err == nil {
+		cacheChecksums(p, st, map[string]string{u.algo: u.cs.Sums()[u.algo]})
+	}
+}
@@ -0,0 +1,159 @@
+package routes
+
+import (
+	"compress/flate"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+// compressPaths builds a zip or tar.gz of paths inside toPath. The archive is
+// written to a hidden temp file in the same directory and renamed into place
+// once complete, so a partial archive is never visible under its real name.
+func compressPaths(c echo.Context) error {
+	var body struct {
+		Paths    []string `json:"paths"`
+		ToPath   string   `json:"toPath"`
+		Name     string   `json:"name"`
+		Format   string   `json:"format"`
+		Level    *int     `json:"level"`
+		Exclude  []string `json:"exclude"`
+		Conflict string   `json:"conflict"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if len(body.Paths) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "paths is required"})
+	}
+	for _, p := range body.Paths {
+		if !isPathSafe(c, p) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + p})
+		}
+		if !isExist(p) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path not found: " + p})
+		}
+	}
+	if !isPathSafe(c, body.ToPath) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + body.ToPath})
+	}
+
+	format := body.Format
+	if format == "" {
+		format = utils.ArchiveFormat(body.Name)
+		if format == "" {
+			format = "zip"
+		}
+	}
+	if format != "zip" && format != "tar" && format != "tar.gz" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unsupported archive format: " + format})
+	}
+	name := body.Name
+	if name == "" {
+		name = filepath.Base(body.Paths[0])
+		if len(body.Paths) > 1 {
+			name = filepath.Base(filepath.Dir(body.Paths[0]))
+		}
+	}
+	if utils.ArchiveFormat(name) != format {
+		name += "." + format
+	}
+	name, err := sanitizeUploadFilename(name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid name"})
+	}
+
+	level := flate.DefaultCompression
+	if body.Level != nil {
+		level = *body.Level
+		if level < flate.DefaultCompression || level > flate.BestCompression {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "level must be between -1 and 9"})
+		}
+	}
+	for _, pattern := range body.Exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid exclude pattern: " + pattern})
+		}
+	}
+
+	target := filepath.Join(body.ToPath, name)
+	switch body.Conflict {
+	case "", "rename":
+		if isExist(target) {
+			target = availableName(target)
+		}
+	case "overwrite":
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unknown conflict policy: " + body.Conflict})
+	}
+	if st, err := os.Stat(target); err == nil && st.IsDir() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Target is a directory: " + target})
+	}
+
+	user := middlewares.CurrentUser(c)
+	j := jobs.submit(user, "compress", body.Paths, target, func(j *job) {
+		j.measure(body.Paths)
+		if err := writeArchiveFile(j, format, body.Paths, target, utils.ArchiveOptions{
+			Level:      level,
+			Exclude:    body.Exclude,
+			FollowLink: func(p string) bool { return isPathWithin(user.Root, p) },
+		}); err != nil {
+			if j.canceled() == nil {
+				j.addError(target, err.Error())
+			}
+			return
+		}
+		// The archive is in place; skipped entries make the job partial.
+		j.complete()
+	})
+	return c.JSON(http.StatusAccepted, map[string]string{"path": target, "jobId": j.status.ID})
+}
+
+func writeArchiveFile(j *job, format string, paths []string, target string, opts utils.ArchiveOptions) error {
+	target, err := filepath.Abs(target)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(target)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+
+	// The archive may be created inside one of the directories being archived.
+	opts.Skip = func(p string) bool {
+		abs, _ := filepath.Abs(p)
+		return abs == tmpName || abs == target
+	}
+	opts.Wrap = func(r io.Reader) io.Reader {
+		j.addDone(0, 1)
+		return &jobReader{j: j, r: r}
+	}
+	opts.OnSkip = func(p string, err error) { j.addError(p, err.Error()) }
+	err = utils.WriteArchive(format, paths, tmp, opts)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, 0644); err != nil {
+		return err
+	}
+	if err := snapshotVersion(target, j.owner.Username); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, target)
+}
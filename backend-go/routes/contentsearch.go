@@ -0,0 +1,350 @@
+package routes
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+const (
+	contentSearchDefaultLimit = 50
+	contentSearchMaxLimit     = 500
+	contentSearchMaxSnippets  = 5
+	contentSearchSnippetLen   = 200
+)
+
+// contentIndexer crawls safeBaseDir and every user's root in the background
+// and keeps a full-text index of their text files in
+// DATA_BASE_DIR/content-index. Only files whose mtime or size changed since
+// the last crawl are re-read.
+type contentIndexer struct {
+	index *utils.TextIndex
+	// wake starts the next crawl before the interval is up.
+	wake chan struct{}
+
+	mu        sync.Mutex
+	indexing  bool
+	updatedAt int64
+}
+
+var contentIndexOnce sync.Once
+var contentIdx *contentIndexer
+
+func registerContentSearch(g *echo.Group, read echo.MiddlewareFunc) {
+	g.GET("/content-search", func(c echo.Context) error { return contentSearch(c) }, read)
+	g.POST("/content-search/refresh", func(c echo.Context) error { return refreshContentIndex(c) }, read)
+
+	if !config.Config().ContentIndex {
+		return
+	}
+	contentIndexOnce.Do(func() {
+		if len(contentIndexRoots()) == 0 {
+			fmt.Println("content index: neither safeBaseDir nor any user root is set, indexing disabled")
+			return
+		}
+		index, err := utils.LoadTextIndex(contentIndexFile())
+		if err != nil {
+			index = utils.NewTextIndex()
+		}
+		contentIdx = &contentIndexer{index: index, wake: make(chan struct{}, 1)}
+		go contentIdx.run()
+	})
+}
+
+// contentIndexRoots returns safeBaseDir and the users' roots, leaving out
+// roots nested in another one so no file is crawled twice. An empty
+// safeBaseDir means the whole filesystem and is not indexed.
+func contentIndexRoots() []string {
+	var candidates []string
+	if root := config.SafeBaseDir(); root != "" {
+		candidates = append(candidates, root)
+	}
+	for _, u := range config.Users() {
+		if u.Root != "" {
+			candidates = append(candidates, u.Root)
+		}
+	}
+	sort.Strings(candidates)
+	var roots []string
+	for _, r := range candidates {
+		nested := false
+		for _, kept := range roots {
+			if isPathWithin(kept, r) {
+				nested = true
+				break
+			}
+		}
+		if !nested {
+			roots = append(roots, r)
+		}
+	}
+	return roots
+}
+
+func contentIndexFile() string {
+	return filepath.Join(config.DataBaseDir(), "content-index", "index.gob")
+}
+
+func (ix *contentIndexer) run() {
+	cfg := config.Config()
+	maxSize := cfg.ContentIndexMaxFileKB << 10
+	if maxSize <= 0 {
+		maxSize = 1 << 20
+	}
+	interval := time.Duration(cfg.ContentIndexIntervalMin) * time.Minute
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	for {
+		ix.mu.Lock()
+		ix.indexing = true
+		ix.mu.Unlock()
+
+		if ix.crawl(contentIndexRoots(), maxSize) {
+			if err := ix.index.Save(contentIndexFile()); err != nil {
+				fmt.Println("content index: save failed:", err)
+			}
+		}
+
+		ix.mu.Lock()
+		ix.indexing = false
+		ix.updatedAt = time.Now().UnixMilli()
+		ix.mu.Unlock()
+		select {
+		case <-time.After(interval):
+		case <-ix.wake:
+		}
+	}
+}
+
+// crawl brings the index up to date with the trees under roots and reports
+// whether anything changed. Binary files are recorded without terms so they
+// are not sniffed again until they change.
+func (ix *contentIndexer) crawl(roots []string, maxSize int64) bool {
+	changed := false
+	seen := map[string]bool{}
+	for _, root := range roots {
+		if ix.crawlRoot(root, maxSize, seen) {
+			changed = true
+		}
+	}
+	for _, p := range ix.index.Paths() {
+		if !seen[p] {
+			ix.index.Remove(p)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (ix *contentIndexer) crawlRoot(root string, maxSize int64, seen map[string]bool) bool {
+	changed := false
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && (d.Name() == volumeTrashDirName || d.Name() == ".git" || isPathWithin(config.DataBaseDir(), p)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() > maxSize {
+			return nil
+		}
+		seen[p] = true
+		modTime := info.ModTime().UnixNano()
+		if mt, size, ok := ix.index.Lookup(p); ok && mt == modTime && size == info.Size() {
+			return nil
+		}
+		doc := &utils.IndexedDoc{Path: p, ModTime: modTime, Size: info.Size()}
+		if utils.IsTextFile(p) {
+			terms, err := utils.TokenizeFile(p)
+			if err != nil {
+				return nil
+			}
+			doc.Terms = terms
+		}
+		ix.index.Put(doc)
+		changed = true
+		return nil
+	})
+	return changed
+}
+
+type contentSnippet struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+type contentHit struct {
+	Path  string           `json:"path"`
+	Name  string           `json:"name"`
+	Lines []contentSnippet `json:"lines"`
+}
+
+// matchLines returns the lines of p that contain every term or, when the terms
+// are spread over several lines, the lines that contain any of them. No lines
+// at all means the index entry went stale since the last crawl.
+func matchLines(p string, terms []string) ([]contentSnippet, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var all, some []contentSnippet
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for n := 1; sc.Scan() && len(all) < contentSearchMaxSnippets; n++ {
+		text := sc.Text()
+		lower := strings.ToLower(text)
+		first, found := -1, 0
+		for _, t := range terms {
+			if i := strings.Index(lower, t); i >= 0 {
+				found++
+				if first < 0 || i < first {
+					first = i
+				}
+			}
+		}
+		if found == 0 {
+			continue
+		}
+		snippet := contentSnippet{Line: n, Text: snippetAround(text, utf8.RuneCountInString(lower[:first]))}
+		if found == len(terms) {
+			all = append(all, snippet)
+		} else if len(some) < contentSearchMaxSnippets {
+			some = append(some, snippet)
+		}
+	}
+	if len(all) > 0 {
+		return all, sc.Err()
+	}
+	return some, sc.Err()
+}
+
+// snippetAround shortens long lines to a window around the rune at index at.
+func snippetAround(line string, at int) string {
+	r := []rune(line)
+	if len(r) <= contentSearchSnippetLen {
+		return line
+	}
+	start := at - contentSearchSnippetLen/4
+	if start < 0 {
+		start = 0
+	}
+	end := start + contentSearchSnippetLen
+	if end > len(r) {
+		end = len(r)
+		start = end - contentSearchSnippetLen
+	}
+	s := string(r[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(r) {
+		s += "…"
+	}
+	return s
+}
+
+func contentSearch(c echo.Context) error {
+	if contentIdx == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": "Content index is disabled"})
+	}
+	u := middlewares.CurrentUser(c)
+	scope := c.QueryParam("path")
+	if scope == "" {
+		scope = u.Root
+		if scope == "" {
+			scope = config.SafeBaseDir()
+		}
+	}
+	if !isPathSafe(c, scope) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	query := utils.ParseQuery(c.QueryParam("q"))
+	terms := make([]string, len(query))
+	for i, t := range query {
+		terms[i] = t.Text
+	}
+	if len(terms) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Query is too short"})
+	}
+	limit := contentSearchDefaultLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid limit"})
+		}
+		limit = n
+	}
+	if limit > contentSearchMaxLimit {
+		limit = contentSearchMaxLimit
+	}
+
+	paths := contentIdx.index.Search(query)
+	sort.Strings(paths)
+	results := []contentHit{}
+	truncated := false
+	for _, p := range paths {
+		if c.Request().Context().Err() != nil {
+			return nil
+		}
+		if !isPathWithin(scope, p) || !isPathSafe(c, p) {
+			continue
+		}
+		if len(results) == limit {
+			truncated = true
+			break
+		}
+		lines, err := matchLines(p, terms)
+		if err != nil || len(lines) == 0 {
+			continue
+		}
+		results = append(results, contentHit{Path: p, Name: filepath.Base(p), Lines: lines})
+	}
+
+	contentIdx.mu.Lock()
+	indexing, updatedAt := contentIdx.indexing, contentIdx.updatedAt
+	contentIdx.mu.Unlock()
+	return c.JSON(http.StatusOK, map[string]any{
+		"results":      results,
+		"truncated":    truncated,
+		"indexedFiles": contentIdx.index.Len(),
+		"indexing":     indexing,
+		"updatedAt":    updatedAt,
+	})
+}
+
+// refreshContentIndex starts a crawl now instead of at the next interval.
+// Requests while one is pending are folded into it.
+func refreshContentIndex(c echo.Context) error {
+	if contentIdx == nil {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"message": "Content index is disabled"})
+	}
+	select {
+	case contentIdx.wake <- struct{}{}:
+	default:
+	}
+	return c.NoContent(http.StatusAccepted)
+}
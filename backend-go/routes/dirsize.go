@@ -0,0 +1,87 @@
+package routes
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/utils"
+)
+
+const dirSizeConcurrency = 16
+
+// dirSizeChild is one line of the per-child breakdown, like du --max-depth=1.
+type dirSizeChild struct {
+	Name        string `json:"name"`
+	IsDirectory bool   `json:"isDirectory"`
+	utils.DirUsage
+}
+
+// getDirSize adds up everything below path and breaks the total down by its
+// direct children, largest first. What each directory holds directly is cached
+// by its mtime for up to utils.DirSizeCacheTTL; refresh=true reads everything
+// again and refreshes the cache. The walk stops when the client goes away.
+func getDirSize(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	if !st.IsDir() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a directory"})
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to read directory"})
+	}
+	refresh, _ := strconv.ParseBool(c.QueryParam("refresh"))
+
+	start := time.Now()
+	ctx := c.Request().Context()
+	sizer := utils.NewDirSizer(dirSizeConcurrency, !refresh)
+	var total utils.DirUsage
+	children := make([]dirSizeChild, 0, len(entries))
+	for _, e := range entries {
+		child := dirSizeChild{Name: e.Name(), IsDirectory: e.IsDir()}
+		if e.IsDir() {
+			u, err := sizer.Size(ctx, filepath.Join(path, e.Name()))
+			if err != nil {
+				// Only cancellation fails a walk: the client is gone.
+				return nil
+			}
+			child.DirUsage = u
+			total.Dirs++
+		} else if info, err := e.Info(); err == nil {
+			child.Bytes = info.Size()
+			child.Files = 1
+		} else {
+			child.Errors = 1
+		}
+		total.Bytes += child.Bytes
+		total.Files += child.Files
+		total.Dirs += child.Dirs
+		total.Errors += child.Errors
+		children = append(children, child)
+	}
+	sort.SliceStable(children, func(a, b int) bool { return children[a].Bytes > children[b].Bytes })
+	return c.JSON(http.StatusOK, map[string]any{
+		"path":      path,
+		"bytes":     total.Bytes,
+		"files":     total.Files,
+		"dirs":      total.Dirs,
+		"errors":    total.Errors,
+		"children":  children,
+		"elapsedMs": time.Since(start).Milliseconds(),
+	})
+}
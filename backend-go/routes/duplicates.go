@@ -0,0 +1,396 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+)
+
+// dupPartialSize is how much of each file the partial hash reads, from the
+// start and from the end.
+const dupPartialSize = 16 << 10
+
+// dupGroup is a set of files with identical content. stats keeps what each
+// path looked like when it was hashed.
+type dupGroup struct {
+	Size  int64    `json:"size"`
+	Hash  string   `json:"hash"`
+	Paths []string `json:"paths"`
+	stats map[string]os.FileInfo
+}
+
+// dupScan collects the groups of a duplicates job as they are confirmed.
+// notify is closed and replaced on every change so streams can wait on it.
+type dupScan struct {
+	mu     sync.Mutex
+	groups []dupGroup
+	done   bool
+	notify chan struct{}
+}
+
+var dupScans = struct {
+	sync.Mutex
+	m map[string]*dupScan
+}{m: map[string]*dupScan{}}
+
+func registerDuplicates(g *echo.Group, read, remove echo.MiddlewareFunc) {
+	g.POST("/duplicates", func(c echo.Context) error { return findDuplicates(c) }, read)
+	g.GET("/duplicates/:id", func(c echo.Context) error { return streamDuplicates(c) }, read)
+	g.POST("/duplicates/:id/delete", func(c echo.Context) error { return deleteDuplicates(c) }, remove)
+}
+
+func (s *dupScan) add(g dupGroup) {
+	s.mu.Lock()
+	s.groups = append(s.groups, g)
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mu.Unlock()
+}
+
+func (s *dupScan) finish() {
+	s.mu.Lock()
+	s.done = true
+	close(s.notify)
+	s.notify = make(chan struct{})
+	s.mu.Unlock()
+}
+
+// dupScanFor returns the scan of a job u can see, or nil.
+func dupScanFor(u config.User, id string) *dupScan {
+	if jobs.get(u, id) == nil {
+		return nil
+	}
+	dupScans.Lock()
+	defer dupScans.Unlock()
+	return dupScans.m[id]
+}
+
+// findDuplicates starts a job that groups the regular files under path by
+// size, then by a hash of their first and last dupPartialSize bytes, then by
+// a SHA-256 of the whole content. Symlinks are not followed and hard links to
+// the same file count once.
+func findDuplicates(c echo.Context) error {
+	var body struct {
+		Path    string `json:"path"`
+		MinSize int64  `json:"minSize"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if !isPathSafe(c, body.Path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	if st, err := os.Stat(body.Path); err != nil || !st.IsDir() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a directory"})
+	}
+	minSize := body.MinSize
+	if minSize <= 0 {
+		minSize = 1
+	}
+
+	scan := &dupScan{notify: make(chan struct{})}
+	root := body.Path
+	j := jobs.submit(middlewares.CurrentUser(c), "duplicates", []string{root}, "", func(j *job) {
+		defer scan.finish()
+		findDuplicateGroups(j, root, minSize, scan.add)
+	})
+	dupScans.Lock()
+	for id := range dupScans.m {
+		if !jobs.exists(id) {
+			delete(dupScans.m, id)
+		}
+	}
+	dupScans.m[j.status.ID] = scan
+	dupScans.Unlock()
+	// A job rejected by a full queue never runs to finish the scan itself.
+	if j.snapshot().FinishedAt != 0 {
+		scan.finish()
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"path": root, "jobId": j.status.ID})
+}
+
+type dupFile struct {
+	path string
+	info os.FileInfo
+}
+
+func findDuplicateGroups(j *job, root string, minSize int64, emit func(dupGroup)) {
+	bySize := map[int64][]dupFile{}
+	_ = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if j.canceled() != nil {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && (d.Name() == volumeTrashDirName || isPathWithin(config.DataBaseDir(), p)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() < minSize {
+			return nil
+		}
+		for _, f := range bySize[info.Size()] {
+			if os.SameFile(f.info, info) {
+				return nil
+			}
+		}
+		bySize[info.Size()] = append(bySize[info.Size()], dupFile{path: p, info: info})
+		return nil
+	})
+
+	sizes := make([]int64, 0, len(bySize))
+	for size, files := range bySize {
+		if len(files) > 1 {
+			sizes = append(sizes, size)
+			j.addTotal(size*int64(len(files)), int64(len(files)))
+		}
+	}
+	// Largest first: those are the copies worth finding.
+	sort.Slice(sizes, func(a, b int) bool { return sizes[a] > sizes[b] })
+
+	for _, size := range sizes {
+		for _, files := range groupByHash(j, bySize[size], partialHash) {
+			if j.canceled() != nil {
+				return
+			}
+			if len(files) < 2 {
+				j.addDone(size*int64(len(files)), int64(len(files)))
+				continue
+			}
+			for hash, same := range groupByHash(j, files, fullHash) {
+				if len(same) < 2 {
+					continue
+				}
+				g := dupGroup{Size: size, Hash: hash, stats: map[string]os.FileInfo{}}
+				for _, f := range same {
+					g.Paths = append(g.Paths, f.path)
+					g.stats[f.path] = f.info
+				}
+				sort.Strings(g.Paths)
+				emit(g)
+			}
+		}
+	}
+}
+
+// groupByHash buckets files by hash; files that cannot be read are recorded
+// on the job and left out.
+func groupByHash(j *job, files []dupFile, hash func(j *job, f dupFile) (string, error)) map[string][]dupFile {
+	groups := map[string][]dupFile{}
+	for _, f := range files {
+		if j.canceled() != nil {
+			break
+		}
+		h, err := hash(j, f)
+		if err != nil {
+			if j.canceled() == nil {
+				j.addError(f.path, err.Error())
+			}
+			continue
+		}
+		groups[h] = append(groups[h], f)
+	}
+	return groups
+}
+
+func partialHash(_ *job, f dupFile) (string, error) {
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	if _, err := io.CopyN(h, fh, dupPartialSize); err != nil && err != io.EOF {
+		return "", err
+	}
+	if size := f.info.Size(); size > 2*dupPartialSize {
+		if _, err := io.Copy(h, io.NewSectionReader(fh, size-dupPartialSize, dupPartialSize)); err != nil {
+			return "", err
+		}
+	} else if size > dupPartialSize {
+		if _, err := io.Copy(h, fh); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func fullHash(j *job, f dupFile) (string, error) {
+	fh, err := os.Open(f.path)
+	if err != nil {
+		return "", err
+	}
+	defer fh.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, &jobReader{j: j, r: fh}); err != nil {
+		return "", err
+	}
+	j.addDone(0, 1)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// streamDuplicates sends the groups of a duplicates job as NDJSON, first the
+// ones found so far and then each new one as it is confirmed, ending with a
+// {"done":true,"status":...,"groups":n} line once the job has finished.
+// Groups left with a single copy by an earlier delete are not sent.
+func streamDuplicates(c echo.Context) error {
+	id := c.Param("id")
+	scan := dupScanFor(middlewares.CurrentUser(c), id)
+	if scan == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Job not found"})
+	}
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	ctx := c.Request().Context()
+	seen, sent := 0, 0
+	for {
+		scan.mu.Lock()
+		groups := append([]dupGroup(nil), scan.groups[seen:]...)
+		done := scan.done
+		notify := scan.notify
+		scan.mu.Unlock()
+		for _, g := range groups {
+			if len(g.Paths) < 2 {
+				continue
+			}
+			if err := enc.Encode(g); err != nil {
+				return nil
+			}
+			sent++
+		}
+		seen += len(groups)
+		w.Flush()
+		if done {
+			break
+		}
+		if !waitNotify(ctx, notify) {
+			return nil
+		}
+	}
+	var status string
+	if j := jobs.get(middlewares.CurrentUser(c), id); j != nil {
+		status = j.snapshot().Status
+	}
+	return enc.Encode(map[string]any{"done": true, "status": status, "groups": sent})
+}
+
+func waitNotify(ctx context.Context, notify <-chan struct{}) bool {
+	select {
+	case <-notify:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// deleteDuplicates deletes the chosen copies through the same checks and job
+// as POST /files/delete. Every path must belong to a group of the scan, and
+// at least one copy of each group has to be kept. The chosen copies and a
+// kept one must still have the size and mtime seen by the scan, so a file
+// changed since then is never taken for a duplicate. Once the delete job is
+// queued the paths are dropped from the scan.
+func deleteDuplicates(c echo.Context) error {
+	var body struct {
+		Paths     []string `json:"paths"`
+		Permanent bool     `json:"permanent"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	scan := dupScanFor(middlewares.CurrentUser(c), c.Param("id"))
+	if scan == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Job not found"})
+	}
+	if len(body.Paths) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "paths is required"})
+	}
+	selected := map[string]bool{}
+	var paths []string
+	for _, p := range body.Paths {
+		if !selected[p] {
+			selected[p] = true
+			paths = append(paths, p)
+		}
+	}
+	// Held until the job is queued so two deletes cannot each keep the copy
+	// the other removes.
+	scan.mu.Lock()
+	defer scan.mu.Unlock()
+	groupOf := map[string]int{}
+	for i, g := range scan.groups {
+		for _, p := range g.Paths {
+			groupOf[p] = i
+		}
+	}
+	touched := map[int]bool{}
+	for _, p := range paths {
+		i, ok := groupOf[p]
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Not a duplicate found by this scan: " + p})
+		}
+		if !scan.groups[i].unchanged(p) {
+			return c.JSON(http.StatusConflict, map[string]string{"message": "File changed since the scan: " + p})
+		}
+		touched[i] = true
+	}
+	for i := range touched {
+		g := scan.groups[i]
+		kept := false
+		for _, p := range g.Paths {
+			if !selected[p] && g.unchanged(p) {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "At least one unchanged copy must be kept: " + g.Paths[0]})
+		}
+	}
+	if err := submitDelete(c, paths, body.Permanent, paths); err != nil || c.Response().Status != http.StatusAccepted {
+		return err
+	}
+	for i := range touched {
+		g := &scan.groups[i]
+		var left []string
+		for _, p := range g.Paths {
+			if !selected[p] {
+				left = append(left, p)
+			}
+		}
+		g.Paths = left
+	}
+	return nil
+}
+
+// unchanged reports whether p still has the size and mtime it was hashed with.
+func (g dupGroup) unchanged(p string) bool {
+	was, ok := g.stats[p]
+	if !ok {
+		return false
+	}
+	st, err := os.Lstat(p)
+	return err == nil && st.Mode().IsRegular() && st.Size() == was.Size() && st.ModTime().Equal(was.ModTime())
+}
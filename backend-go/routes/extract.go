@@ -0,0 +1,205 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+// Limits against archive bombs: a small archive must not be able to fill the
+// disk or the inode table.
+const (
+	extractMaxEntries = 100000
+	extractMaxBytes   = 16 << 30
+)
+
+func extractArchive(c echo.Context) error {
+	var body struct {
+		Path     string `json:"path"`
+		ToPath   string `json:"toPath"`
+		Conflict string `json:"conflict"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if !isPathSafe(c, body.Path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + body.Path})
+	}
+	if !isPathSafe(c, body.ToPath) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + body.ToPath})
+	}
+	switch body.Conflict {
+	case "", "rename", "overwrite", "skip":
+	default:
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unknown conflict policy: " + body.Conflict})
+	}
+	st, err := os.Stat(body.Path)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found: " + body.Path})
+	}
+	if st.IsDir() || utils.ArchiveFormat(body.Path) == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unsupported archive format"})
+	}
+	files, bytes, known, err := utils.ArchiveTotals(body.Path)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Failed to read archive: " + err.Error()})
+	}
+	if known && (files > extractMaxEntries || bytes > extractMaxBytes) {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": "Archive is too large to extract"})
+	}
+
+	user := middlewares.CurrentUser(c)
+	j := jobs.submit(user, "extract", []string{body.Path}, body.ToPath, func(j *job) {
+		if known {
+			j.addTotal(bytes, files)
+		}
+		if err := extractTo(j, user.Root, body.Path, body.ToPath, body.Conflict, !known); err != nil && j.canceled() == nil {
+			j.addError(body.Path, err.Error())
+		}
+	})
+	return c.JSON(http.StatusAccepted, map[string]string{"path": body.ToPath, "jobId": j.status.ID})
+}
+
+// extractTo unpacks archive into toDir. Every entry must resolve inside both
+// toDir and root, and directories are created without following symlinks, so
+// neither "../" names nor links already present in toDir let an entry escape.
+// Files that already exist are handled like a trash restore: rename,
+// overwrite (the old file goes to the trash) or skip. Existing directories are
+// merged, and a file entry never replaces a directory.
+func extractTo(j *job, root, archive, toDir, conflict string, countTotals bool) error {
+	if err := os.MkdirAll(toDir, 0755); err != nil {
+		return err
+	}
+	toDir = filepath.Clean(toDir)
+	var entries int
+	var written int64
+	return utils.WalkArchive(archive, func(e *utils.ArchiveEntry) error {
+		if err := j.canceled(); err != nil {
+			return err
+		}
+		entries++
+		if entries > extractMaxEntries {
+			return fmtError("Archive has more than %d entries", extractMaxEntries)
+		}
+		target := filepath.Join(toDir, filepath.FromSlash(e.Name))
+		if target == toDir && e.IsDir {
+			// "./" in tar archives made with "tar c ."
+			return nil
+		}
+		if target == toDir || !isPathWithin(toDir, target) || !isPathWithin(root, target) {
+			j.addError(e.Name, "Path is not safe")
+			return nil
+		}
+		if e.IsDir {
+			if err := mkdirNoFollow(toDir, target); err != nil {
+				j.addError(e.Name, err.Error())
+			}
+			return nil
+		}
+
+		if countTotals {
+			j.addTotal(e.Size, 1)
+		}
+		if err := mkdirNoFollow(toDir, filepath.Dir(target)); err != nil {
+			j.addError(e.Name, err.Error())
+			return nil
+		}
+		if st, err := os.Lstat(target); err == nil && st.IsDir() && conflict == "overwrite" {
+			j.addError(e.Name, "Refusing to replace a directory with a file")
+			return nil
+		}
+		dst, err := restoreTarget(target, conflict, j.owner)
+		if err != nil {
+			j.addError(e.Name, err.Error())
+			return nil
+		}
+		if dst == "" {
+			j.addDone(e.Size, 1)
+			return nil
+		}
+		n, err := extractFile(j, e, dst, extractMaxBytes-written)
+		written += n
+		if err != nil {
+			_ = os.Remove(dst)
+			if written > extractMaxBytes {
+				return fmtError("Archive expands to more than %d bytes", int64(extractMaxBytes))
+			}
+			if j.canceled() != nil {
+				return err
+			}
+			j.addError(e.Name, err.Error())
+			return nil
+		}
+		j.addDone(0, 1)
+		return nil
+	})
+}
+
+// extractFile writes one entry to dst, which must not exist yet. At most limit
+// bytes are written; reading one more means the entry is over the limit.
+func extractFile(j *job, e *utils.ArchiveEntry, dst string, limit int64) (int64, error) {
+	r, err := e.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	perm := e.Mode.Perm() | 0600
+	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(f, &jobReader{j: j, r: io.LimitReader(r, limit+1)})
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && n > limit {
+		err = fmtError("Archive is too large to extract")
+	}
+	if err != nil {
+		return n, err
+	}
+	if !e.ModTime.IsZero() {
+		_ = os.Chtimes(dst, e.ModTime, e.ModTime)
+	}
+	return n, nil
+}
+
+// mkdirNoFollow creates dir and its missing parents below base. It fails when
+// a component is a symlink or a file, unlike os.MkdirAll which follows links.
+func mkdirNoFollow(base, dir string) error {
+	rel, err := filepath.Rel(base, dir)
+	if err != nil {
+		return err
+	}
+	if rel == "." {
+		return nil
+	}
+	p := base
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		st, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			if err := os.Mkdir(p, 0755); err != nil && !os.IsExist(err) {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return fmtError("Refusing to extract through symlink: %s", p)
+		}
+		if !st.IsDir() {
+			return fmtError("Not a directory: %s", p)
+		}
+	}
+	return nil
+}
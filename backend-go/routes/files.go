@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"compress/flate"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,13 +10,14 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
-	"sync"
 
 	"github.com/labstack/echo/v4"
 	etag "github.com/pablor21/echo-etag/v4"
 
 	"file-lite-go/config"
+	"file-lite-go/middlewares"
 	"file-lite-go/types"
 	"file-lite-go/utils"
 )
@@ -23,24 +25,57 @@ import (
 const readDirStatConcurrency = 64
 
 func registerFiles(g *echo.Group) {
-	g.GET("/auth", func(c echo.Context) error { return c.JSON(http.StatusOK, map[string]any{}) })
-	g.GET("/drives", func(c echo.Context) error { return getDrives(c) })
-	g.GET("/list", func(c echo.Context) error { return getFiles(c) }, etag.Etag())
-	g.POST("/create-dir", func(c echo.Context) error { return createDirectory(c) })
-	g.POST("/rename", func(c echo.Context) error { return renamePath(c) })
+	read := middlewares.RequirePermission(config.PermRead)
+	upload := middlewares.RequirePermission(config.PermUpload)
+	rename := middlewares.RequirePermission(config.PermRename)
+	remove := middlewares.RequirePermission(config.PermDelete)
+
+	g.GET("/auth", func(c echo.Context) error { return getAuth(c) })
+	g.GET("/drives", func(c echo.Context) error { return getDrives(c) }, read)
+	g.GET("/list", func(c echo.Context) error { return getFiles(c) }, read, skipIfPaged(etag.Etag()))
+	g.GET("/watch", func(c echo.Context) error { return watchDirectory(c) }, read)
+	g.GET("/search", func(c echo.Context) error { return searchFiles(c) }, read)
+	g.GET("/dir-size", func(c echo.Context) error { return getDirSize(c) }, read)
+	g.POST("/create-dir", func(c echo.Context) error { return createDirectory(c) }, upload)
+	g.POST("/rename", func(c echo.Context) error { return renamePath(c) }, rename)
 	g.POST("/copy-paste", func(c echo.Context) error { return copyPastePath(c) })
-	g.POST("/delete", func(c echo.Context) error { return deletePath(c) })
-	g.GET("/stream", func(c echo.Context) error { return getFileStream(c) })
-	g.HEAD("/stream", func(c echo.Context) error { return getFileStream(c) })
-	g.GET("/download", func(c echo.Context) error { return downloadPath(c) })
-	g.POST("/upload-file", func(c echo.Context) error { return uploadFile(c) })
+	g.POST("/extract", func(c echo.Context) error { return extractArchive(c) }, upload)
+	g.POST("/compress", func(c echo.Context) error { return compressPaths(c) }, upload)
+	g.POST("/delete", func(c echo.Context) error { return deletePath(c) }, remove)
+	g.GET("/stream", func(c echo.Context) error { return getFileStream(c) }, read)
+	g.HEAD("/stream", func(c echo.Context) error { return getFileStream(c) }, read)
+	g.GET("/download", func(c echo.Context) error { return downloadPath(c) }, read)
+	g.GET("/thumbnail", func(c echo.Context) error { return getThumbnail(c) }, read)
+	g.GET("/checksum", func(c echo.Context) error { return getChecksum(c) }, read)
+	g.POST("/upload-file", func(c echo.Context) error { return uploadFile(c) }, upload)
+	g.POST("/save", func(c echo.Context) error { return saveFile(c) }, upload)
+	registerUploads(g, upload)
+	registerJobs(g)
+	registerTrash(g)
+	registerContentSearch(g, read)
+	registerAttrs(g, upload)
+	registerUsage(g, read)
+	registerDuplicates(g, read, remove)
+	registerVersions(g, read, upload)
 }
 
-func isPathSafe(p string) bool {
+// isPathSafe checks p against the sandbox of the requesting user.
+func isPathSafe(c echo.Context, p string) bool {
+	return isPathWithin(middlewares.CurrentUser(c).Root, p)
+}
+
+// isEntrySafe is isPathSafe for renaming or deleting p itself; see isEntryWithin.
+func isEntrySafe(c echo.Context, p string) bool {
+	return isEntryWithin(middlewares.CurrentUser(c).Root, p)
+}
+
+// isPathWithin reports whether p lies inside base, applying the symlink policy:
+// unless it is "follow", symlinks on the way to p are resolved (or refused)
+// so a link inside the sandbox cannot lead outside of it.
+func isPathWithin(base string, p string) bool {
 	if p == "" {
 		return false
 	}
-	base := config.SafeBaseDir()
 	if base == "" {
 		return true
 	}
@@ -52,6 +87,27 @@ func isPathSafe(p string) bool {
 	if err != nil {
 		return false
 	}
+	if !isLexicallyWithin(bp, rp) {
+		return false
+	}
+	switch config.SymlinkPolicy() {
+	case config.SymlinkFollow:
+		return true
+	case config.SymlinkDeny:
+		return !hasSymlinkBelow(bp, rp)
+	}
+	rb, err := resolvePath(bp)
+	if err != nil {
+		return false
+	}
+	rr, err := resolvePath(rp)
+	if err != nil {
+		return false
+	}
+	return isLexicallyWithin(rb, rr)
+}
+
+func isLexicallyWithin(bp, rp string) bool {
 	rel, err := filepath.Rel(bp, rp)
 	if err != nil {
 		return false
@@ -61,6 +117,9 @@ func isPathSafe(p string) bool {
 
 func isExist(p string) bool { _, err := os.Stat(p); return err == nil }
 
+// isEntryExist is like isExist but also true for a dangling symlink.
+func isEntryExist(p string) bool { _, err := os.Lstat(p); return err == nil }
+
 func sanitizeUploadFilename(name string) (string, error) {
 	if name == "" || name != filepath.Base(name) || strings.Contains(name, "..") || strings.ContainsAny(name, `/\`) {
 		return "", fmt.Errorf("invalid filename")
@@ -86,7 +145,26 @@ func entryFromStat(name string, st os.FileInfo) types.Entry {
 	}
 
 	modTime := st.ModTime().UnixMilli()
-	return types.Entry{Name: name, Ext: ext, IsDirectory: isDir, Hidden: strings.HasPrefix(name, "."), LastModified: modTime, Birthtime: modTime, Size: size, Error: nil}
+	return types.Entry{Name: name, Ext: ext, IsDirectory: isDir, Hidden: strings.HasPrefix(name, "."), LastModified: modTime, Size: size, Error: nil}
+}
+
+// setBirthtime fills in the creation time of p, or leaves it null where the
+// OS or filesystem does not record one.
+func setBirthtime(entry *types.Entry, p string, st os.FileInfo) {
+	if t := utils.Birthtime(p, st); !t.IsZero() {
+		ms := t.UnixMilli()
+		entry.Birthtime = &ms
+	}
+}
+
+// addEntryDetail fills in the fields of a detail listing: mode, owner,
+// inode and link count.
+func addEntryDetail(entry *types.Entry, p string, st os.FileInfo) {
+	d := utils.StatDetail(p, st)
+	entry.Mode = st.Mode().String()
+	entry.UID, entry.GID = d.UID, d.GID
+	entry.Owner, entry.Group = d.Owner, d.Group
+	entry.Inode, entry.Nlink = d.Inode, d.Nlink
 }
 
 func entryFromStatError(e os.DirEntry, err error) types.Entry {
@@ -103,12 +181,17 @@ func entryFromStatError(e os.DirEntry, err error) types.Entry {
 	}
 
 	msg := err.Error()
-	return types.Entry{Name: name, Ext: ext, IsDirectory: isDir, Hidden: strings.HasPrefix(name, "."), LastModified: 0, Birthtime: 0, Size: size, Error: &msg}
+	return types.Entry{Name: name, Ext: ext, IsDirectory: isDir, Hidden: strings.HasPrefix(name, "."), LastModified: 0, Size: size, Error: &msg}
+}
+
+func getAuth(c echo.Context) error {
+	u := middlewares.CurrentUser(c)
+	return c.JSON(http.StatusOK, map[string]any{"username": u.Username, "admin": u.Admin, "permissions": u.Permissions})
 }
 
 func getDrives(c echo.Context) error {
-	if config.SafeBaseDir() != "" {
-		return c.JSON(http.StatusOK, []types.Drive{{Label: config.SafeBaseDir(), Path: config.SafeBaseDir()}})
+	if root := middlewares.CurrentUser(c).Root; root != "" {
+		return c.JSON(http.StatusOK, []types.Drive{{Label: root, Path: root}})
 	}
 	home, _ := os.UserHomeDir()
 	homeDrive := types.Drive{Label: "Home", Path: home}
@@ -127,7 +210,7 @@ func getDrives(c echo.Context) error {
 
 func getFiles(c echo.Context) error {
 	path := c.QueryParam("path")
-	if !isPathSafe(path) {
+	if !isPathSafe(c, path) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
 	}
 
@@ -145,43 +228,31 @@ func getFiles(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to read directory"})
 	}
-
-	type statJob struct {
-		index int
-		entry os.DirEntry
+	q, err := parseListQuery(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
 	}
 
-	res := make([]types.Entry, len(entries))
-	jobs := make(chan statJob)
-	workerCount := readDirStatConcurrency
-	if len(entries) < workerCount {
-		workerCount = len(entries)
+	// A page only stats its own entries, unless the sort order needs them all.
+	root := middlewares.CurrentUser(c).Root
+	items := q.filter(entries)
+	statAll := !q.paged || q.needsStat()
+	if statAll {
+		statItems(root, path, items, q.detail && !q.paged)
 	}
-	var wg sync.WaitGroup
-
-	for i := 0; i < workerCount; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for job := range jobs {
-				ep := filepath.Join(path, job.entry.Name())
-				st, statErr := os.Stat(ep)
-				if statErr != nil {
-					res[job.index] = entryFromStatError(job.entry, statErr)
-					continue
-				}
-				res[job.index] = entryFromStat(job.entry.Name(), st)
-			}
-		}()
+	q.sortItems(items)
+	if !q.paged {
+		res := make([]types.Entry, len(items))
+		for i := range items {
+			res[i] = items[i].entry
+		}
+		return c.JSON(http.StatusOK, res)
 	}
-
-	for i, e := range entries {
-		jobs <- statJob{index: i, entry: e}
+	page, next := q.page(items)
+	if !statAll || q.detail {
+		statItems(root, path, page, q.detail)
 	}
-	close(jobs)
-	wg.Wait()
-
-	return c.JSON(http.StatusOK, res)
+	return writeListPage(c, len(items), page, next)
 }
 
 func createDirectory(c echo.Context) error {
@@ -191,7 +262,7 @@ func createDirectory(c echo.Context) error {
 	if err := c.Bind(&body); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
 	}
-	if !isPathSafe(body.Path) {
+	if !isPathSafe(c, body.Path) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
 	}
 	if isExist(body.Path) {
@@ -218,13 +289,13 @@ func renamePath(c echo.Context) error {
 	if body.FromPath == body.ToPath {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Paths cannot be the same"})
 	}
-	if !isPathSafe(body.FromPath) || !isPathSafe(body.ToPath) {
+	if !isEntrySafe(c, body.FromPath) || !isPathSafe(c, body.ToPath) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "A specified path is not safe"})
 	}
-	if !isExist(body.FromPath) {
+	if !isEntryExist(body.FromPath) {
 		return c.JSON(http.StatusNotFound, map[string]string{"message": "Source path not found"})
 	}
-	if isExist(body.ToPath) {
+	if isEntryExist(body.ToPath) {
 		return c.JSON(http.StatusConflict, map[string]string{"message": "Destination path already exists"})
 	}
 	if err := os.Rename(body.FromPath, body.ToPath); err != nil {
@@ -233,34 +304,60 @@ func renamePath(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"path": body.ToPath})
 }
 
-func copyEntry(fromPath, toDir string, isMove bool) error {
-	if !isPathSafe(fromPath) || !isPathSafe(toDir) {
+func copyEntry(j *job, root, fromPath, toDir string, isMove bool) error {
+	if !isEntryWithin(root, fromPath) || !isPathWithin(root, toDir) {
 		return fmtError("Path is not safe. From: %s, To: %s", fromPath, toDir)
 	}
-	if !isExist(fromPath) {
+	st, err := os.Lstat(fromPath)
+	if err != nil {
 		return fmtError("Source path does not exist: %s", fromPath)
 	}
 	toPath := filepath.Join(toDir, filepath.Base(fromPath))
-	if isExist(toPath) {
+	if isEntryExist(toPath) {
 		return fmtError("Destination path already exists: %s", toPath)
 	}
-	st, _ := os.Stat(fromPath)
-	if st.IsDir() {
-		if err := copyDir(fromPath, toPath); err != nil {
-			return err
-		}
-	} else {
-		if err := copyFile(fromPath, toPath); err != nil {
-			return err
+	if isMove {
+		// Same filesystem: a rename is instant and needs no copy.
+		if err := os.Rename(fromPath, toPath); err == nil {
+			j.addDone(treeSize(toPath))
+			return nil
 		}
 	}
-	if isMove {
+	failed := j.errorCount()
+	if err := copyAny(j, root, fromPath, toPath, st); err != nil {
+		return err
+	}
+	if isMove && j.errorCount() == failed {
 		_ = os.RemoveAll(fromPath)
 	}
 	return nil
 }
 
-func copyDir(src, dst string) error {
+// copyAny copies src, whose Lstat is st. A symlink the policy lets root follow
+// is copied as what it points to; any other symlink is recreated as a link.
+func copyAny(j *job, root, src, dst string, st os.FileInfo) error {
+	if st.Mode()&os.ModeSymlink != 0 {
+		if !isPathWithin(root, src) {
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(target, dst)
+		}
+		var err error
+		if st, err = os.Stat(src); err != nil {
+			return err
+		}
+	}
+	if st.IsDir() {
+		return copyDir(j, root, src, dst)
+	}
+	return copyFile(j, src, dst)
+}
+
+// copyDir keeps going when a single entry fails; failures are recorded on the
+// job. Only cancellation stops the walk.
+func copyDir(j *job, root, src, dst string) error {
 	entries, err := os.ReadDir(src)
 	if err != nil {
 		return err
@@ -269,23 +366,27 @@ func copyDir(src, dst string) error {
 		return err
 	}
 	for _, e := range entries {
+		if err := j.canceled(); err != nil {
+			return err
+		}
 		sp := filepath.Join(src, e.Name())
 		dp := filepath.Join(dst, e.Name())
-		st, _ := os.Stat(sp)
-		if st.IsDir() {
-			if err := copyDir(sp, dp); err != nil {
-				return err
-			}
-		} else {
-			if err := copyFile(sp, dp); err != nil {
-				return err
+		st, err := os.Lstat(sp)
+		if err != nil {
+			j.addError(sp, err.Error())
+			continue
+		}
+		if err := copyAny(j, root, sp, dp, st); err != nil {
+			if cerr := j.canceled(); cerr != nil {
+				return cerr
 			}
+			j.addError(sp, err.Error())
 		}
 	}
 	return nil
 }
 
-func copyFile(src, dst string) error {
+func copyFile(j *job, src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return err
@@ -296,8 +397,11 @@ func copyFile(src, dst string) error {
 		return err
 	}
 	defer out.Close()
-	_, err = io.Copy(out, in)
-	return err
+	if _, err = io.Copy(out, &jobReader{j: j, r: in}); err != nil {
+		return err
+	}
+	j.addDone(0, 1)
+	return nil
 }
 
 func copyPastePath(c echo.Context) error {
@@ -309,12 +413,29 @@ func copyPastePath(c echo.Context) error {
 	if err := c.Bind(&body); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
 	}
-	for _, p := range body.FromPaths {
-		if err := copyEntry(p, body.ToPath, body.IsMove); err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
-		}
+	user := middlewares.CurrentUser(c)
+	perm, typ := config.PermUpload, "copy"
+	if body.IsMove {
+		perm, typ = config.PermRename, "move"
 	}
-	return c.JSON(http.StatusOK, map[string]string{"path": body.ToPath})
+	if !user.Permissions.Allows(perm) {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "Permission denied: " + perm})
+	}
+	if !isPathSafe(c, body.ToPath) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + body.ToPath})
+	}
+	j := jobs.submit(user, typ, body.FromPaths, body.ToPath, func(j *job) {
+		j.measure(body.FromPaths)
+		for _, p := range body.FromPaths {
+			if j.canceled() != nil {
+				return
+			}
+			if err := copyEntry(j, user.Root, p, body.ToPath, body.IsMove); err != nil && j.canceled() == nil {
+				j.addError(p, err.Error())
+			}
+		}
+	})
+	return c.JSON(http.StatusAccepted, map[string]string{"path": body.ToPath, "jobId": j.status.ID})
 }
 
 func deletePath(c echo.Context) error {
@@ -336,23 +457,46 @@ func deletePath(c echo.Context) error {
 	default:
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
 	}
+	// Entries go to the trash unless permanent deletion is explicitly requested.
+	permanent, _ := raw["permanent"].(bool)
+	return submitDelete(c, paths, permanent, v)
+}
+
+// submitDelete checks paths and starts a delete job for them; v is echoed
+// back as "path" in the response.
+func submitDelete(c echo.Context, paths []string, permanent bool, v any) error {
 	for _, p := range paths {
-		if !isPathSafe(p) {
+		if !isEntrySafe(c, p) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + p})
 		}
-		if !isExist(p) {
+		if !isEntryExist(p) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path not found: " + p})
 		}
 	}
-	for _, p := range paths {
-		_ = os.RemoveAll(p)
-	}
-	return c.JSON(http.StatusOK, map[string]any{"path": v})
+	user := middlewares.CurrentUser(c)
+	j := jobs.submit(user, "delete", paths, "", func(j *job) {
+		j.addTotal(0, int64(len(paths)))
+		for _, p := range paths {
+			if j.canceled() != nil {
+				return
+			}
+			remove := func(p string) error { return moveToTrash(user, p) }
+			if permanent {
+				remove = os.RemoveAll
+			}
+			if err := remove(p); err != nil {
+				j.addError(p, err.Error())
+				continue
+			}
+			j.addDone(0, 1)
+		}
+	})
+	return c.JSON(http.StatusAccepted, map[string]any{"path": v, "jobId": j.status.ID})
 }
 
 func getFileStream(c echo.Context) error {
 	path := c.QueryParam("path")
-	if !isPathSafe(path) {
+	if !isPathSafe(c, path) {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
 	}
 	if !isExist(path) {
@@ -364,10 +508,21 @@ func getFileStream(c echo.Context) error {
 	}
 	name := filepath.Base(path)
 	c.Response().Header().Set("Content-Disposition", utils.InlineDisposition(name))
+	// The ETag lets editors send it back to POST /files/save as If-Match.
+	c.Response().Header().Set("ETag", fileETag(st))
 	return c.File(path)
 }
 
-func downloadMulti(paths []string, c echo.Context) error {
+// archiveContentTypes lists the formats downloadMulti can stream.
+var archiveContentTypes = map[string]string{
+	"zip":    "application/zip",
+	"tar":    "application/x-tar",
+	"tar.gz": "application/gzip",
+}
+
+// downloadMulti archives paths; symlinks are followed only where the symlink
+// policy lets root follow them.
+func downloadMulti(paths []string, format, root string, c echo.Context) error {
 	if len(paths) == 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "No files to download"})
 	}
@@ -380,13 +535,44 @@ func downloadMulti(paths []string, c echo.Context) error {
 	if downloadName == "" {
 		downloadName = "download"
 	}
-	t := downloadName + ".zip"
-	c.Response().Header().Set("Content-Disposition", utils.AttachmentDisposition(t))
-	c.Response().Header().Set("Content-Type", "application/zip")
+	t := downloadName + "." + format
+	// Unreadable entries are listed in an _errors.txt inside the archive, logged,
+	// and counted in the X-Archive-Errors trailer when the response is chunked.
+	skipped := 0
+	opts := utils.ArchiveOptions{
+		Level:         flate.DefaultCompression,
+		ErrorManifest: true,
+		FollowLink:    func(p string) bool { return isPathWithin(root, p) },
+		OnSkip: func(p string, err error) {
+			skipped++
+			c.Logger().Errorf("download: skipped %s: %v", p, err)
+		},
+	}
+	h := c.Response().Header()
+	h.Set("Content-Disposition", utils.AttachmentDisposition(t))
+	h.Set("Content-Type", archiveContentTypes[format])
+	// With nothing to deflate the zip size is known up front, so clients can show real progress.
+	sized := false
+	if format == "zip" {
+		if size, ok := utils.ZipSize(paths, opts); ok {
+			h.Set("Content-Length", strconv.FormatInt(size, 10))
+			sized = true
+		}
+	}
+	if !sized {
+		h.Set("Trailer", "X-Archive-Errors")
+	}
 	c.Response().WriteHeader(http.StatusOK)
-	return utils.ZipPathsToWriter(paths, c.Response())
+	err := utils.WriteArchive(format, paths, c.Response(), opts)
+	if !sized {
+		h.Set("X-Archive-Errors", strconv.Itoa(skipped))
+	}
+	return err
 }
 
+// downloadPath sends a single file as is. Directories and multiple paths are
+// archived on the fly; "format" picks zip (default), tar or tar.gz, and also
+// forces a single file into an archive when given.
 func downloadPath(c echo.Context) error {
 	q := c.QueryParams()
 	path := q.Get("path")
@@ -402,8 +588,12 @@ func downloadPath(c echo.Context) error {
 	if len(paths) == 0 {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "path(s) parameter is required"})
 	}
+	format := q.Get("format")
+	if _, ok := archiveContentTypes[format]; format != "" && !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Unsupported format: " + format})
+	}
 	for _, p := range paths {
-		if !isPathSafe(p) {
+		if !isPathSafe(c, p) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + p})
 		}
 	}
@@ -413,26 +603,44 @@ func downloadPath(c echo.Context) error {
 			return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
 		}
 		st, _ := os.Stat(p)
-		if !st.IsDir() {
+		if !st.IsDir() && format == "" {
 			name := filepath.Base(p)
 			c.Response().Header().Set("Content-Disposition", utils.AttachmentDisposition(name))
 			return c.File(p)
 		}
 	}
-	return downloadMulti(paths, c)
+	if !middlewares.CurrentUser(c).Permissions.Allows(config.PermDownloadZip) {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "Permission denied: " + config.PermDownloadZip})
+	}
+	if format == "" {
+		format = "zip"
+	}
+	return downloadMulti(paths, format, middlewares.CurrentUser(c).Root, c)
 }
 
 func uploadFile(c echo.Context) error {
 	qPath := c.QueryParam("path")
 	var dest string
 	if qPath != "" {
-		if !isPathSafe(qPath) {
+		if !isPathSafe(c, qPath) {
 			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + qPath})
 		}
 		dest = filepath.Dir(qPath)
 	} else {
 		dest = filepath.Join(config.DataBaseDir(), "uploads")
 	}
+	// An optional "checksum" (algo:hex) is verified before the file takes its name.
+	var check *uploadChecksum
+	var policy string
+	if sum := c.QueryParam("checksum"); sum != "" {
+		var err error
+		if check, err = newUploadChecksum(sum); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		if policy, err = checksumMismatchPolicy(c.QueryParam("checksumMismatch")); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+	}
 	if _, err := os.Stat(dest); err != nil {
 		_ = os.MkdirAll(dest, 0755)
 	}
@@ -449,7 +657,14 @@ func uploadFile(c echo.Context) error {
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid filename"})
 	}
-	out, err := os.Create(filepath.Join(dest, name))
+	if check != nil {
+		return uploadVerified(c, src, filepath.Join(dest, name), check, policy)
+	}
+	target := filepath.Join(dest, name)
+	if err := snapshotVersion(target, middlewares.CurrentUser(c).Username); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to keep the previous version"})
+	}
+	out, err := os.Create(target)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
 	}
@@ -460,6 +675,42 @@ func uploadFile(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "File uploaded successfully!"})
 }
 
+// uploadVerified writes src to a temp file next to target while hashing it,
+// and only renames it into place if the checksum matches or policy is "flag".
+func uploadVerified(c echo.Context, src io.Reader, target string, check *uploadChecksum, policy string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, io.TeeReader(src, check))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	res := check.result()
+	if res.Mismatch && policy == "reject" {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]any{"message": "Checksum mismatch", "expected": res.Expected, "actual": res.Actual, "checksumMismatch": true})
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	if err := snapshotVersion(target, middlewares.CurrentUser(c).Username); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to keep the previous version"})
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	check.remember(target)
+	msg := "File uploaded successfully!"
+	if res.Mismatch {
+		msg = "File uploaded, but its checksum does not match"
+	}
+	return c.JSON(http.StatusOK, map[string]any{"message": msg, "expected": res.Expected, "actual": res.Actual, "checksumMismatch": res.Mismatch})
+}
+
 func urlDecode(s string) string {
 	u, err := url.QueryUnescape(s)
 	if err != nil {
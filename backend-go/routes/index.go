@@ -15,4 +15,6 @@ func Register(api *echo.Group) {
 	files := api.Group("/files")
 	files.Use(middlewares.AuthMiddleware)
 	registerFiles(files)
+	// Public share links: authenticated by the share ID (and optional password), not the token.
+	registerShares(files, api.Group("/share"))
 }
@@ -0,0 +1,331 @@
+package routes
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+)
+
+const (
+	jobWorkerCount = 4
+	jobQueueSize   = 256
+	jobRetention   = time.Hour
+)
+
+const (
+	jobQueued   = "queued"
+	jobRunning  = "running"
+	jobDone     = "done"
+	jobFailed   = "failed"
+	jobCanceled = "canceled"
+	// jobPartial means the job produced its result but left some entries
+	// out; they are listed in errors.
+	jobPartial = "partial"
+)
+
+type jobError struct {
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+type jobStatus struct {
+	ID         string     `json:"id"`
+	Type       string     `json:"type"`
+	Status     string     `json:"status"`
+	Paths      []string   `json:"paths"`
+	ToPath     string     `json:"toPath,omitempty"`
+	TotalBytes int64      `json:"totalBytes"`
+	DoneBytes  int64      `json:"doneBytes"`
+	TotalFiles int64      `json:"totalFiles"`
+	DoneFiles  int64      `json:"doneFiles"`
+	Errors     []jobError `json:"errors"`
+	CreatedAt  int64      `json:"createdAt"`
+	FinishedAt int64      `json:"finishedAt,omitempty"`
+}
+
+// job is a long running filesystem operation. Its methods are safe to call on
+// a nil receiver so the copy helpers can also be used outside of a job.
+type job struct {
+	owner  config.User
+	mu     sync.Mutex
+	status jobStatus
+	// completed is set by jobs whose result is usable despite per-entry errors.
+	completed bool
+	ctx    context.Context
+	cancel context.CancelFunc
+	run    func(j *job)
+}
+
+type jobManager struct {
+	mu    sync.Mutex
+	jobs  map[string]*job
+	queue chan *job
+	once  sync.Once
+}
+
+var jobs = &jobManager{jobs: map[string]*job{}, queue: make(chan *job, jobQueueSize)}
+
+func registerJobs(g *echo.Group) {
+	g.GET("/jobs", func(c echo.Context) error { return listJobs(c) })
+	g.GET("/jobs/:id", func(c echo.Context) error { return getJob(c) })
+	g.POST("/jobs/:id/cancel", func(c echo.Context) error { return cancelJob(c) })
+}
+
+func (m *jobManager) start() {
+	m.once.Do(func() {
+		for i := 0; i < jobWorkerCount; i++ {
+			go func() {
+				for j := range m.queue {
+					j.execute()
+				}
+			}()
+		}
+	})
+}
+
+// submit queues a job and returns it. The job is rejected with a failed status
+// when the queue is full instead of blocking the request.
+func (m *jobManager) submit(owner config.User, typ string, paths []string, toPath string, run func(j *job)) *job {
+	m.start()
+	id, _ := newRandomID()
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		status: jobStatus{ID: id, Type: typ, Status: jobQueued, Paths: paths, ToPath: toPath, Errors: []jobError{}, CreatedAt: time.Now().UnixMilli()},
+		owner:  owner,
+		ctx:    ctx,
+		cancel: cancel,
+		run:    run,
+	}
+	m.mu.Lock()
+	m.cleanup(time.Now())
+	m.jobs[id] = j
+	m.mu.Unlock()
+	select {
+	case m.queue <- j:
+	default:
+		j.addError("", "Job queue is full")
+		j.finish(jobFailed)
+	}
+	return j
+}
+
+// get returns the job only when it belongs to u; the admin sees every job.
+func (m *jobManager) get(u config.User, id string) *job {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j := m.jobs[id]
+	if j == nil || !j.visibleTo(u) {
+		return nil
+	}
+	return j
+}
+
+// exists reports whether the job is still kept, whoever owns it.
+func (m *jobManager) exists(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.jobs[id] != nil
+}
+
+func (m *jobManager) list(u config.User) []jobStatus {
+	m.mu.Lock()
+	m.cleanup(time.Now())
+	list := make([]jobStatus, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		if j.visibleTo(u) {
+			list = append(list, j.snapshot())
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt > list[b].CreatedAt })
+	return list
+}
+
+func (m *jobManager) cleanup(now time.Time) {
+	for id, j := range m.jobs {
+		s := j.snapshot()
+		if s.FinishedAt != 0 && now.Sub(time.UnixMilli(s.FinishedAt)) > jobRetention {
+			delete(m.jobs, id)
+		}
+	}
+}
+
+func (j *job) visibleTo(u config.User) bool {
+	return u.Admin || j.owner.Username == u.Username
+}
+
+func (j *job) execute() {
+	j.mu.Lock()
+	if j.status.Status != jobQueued {
+		j.mu.Unlock()
+		return
+	}
+	j.status.Status = jobRunning
+	j.mu.Unlock()
+
+	j.run(j)
+
+	switch {
+	case j.ctx.Err() != nil:
+		j.finish(jobCanceled)
+	case j.errorCount() > 0 && j.isCompleted():
+		j.finish(jobPartial)
+	case j.errorCount() > 0:
+		j.finish(jobFailed)
+	default:
+		j.finish(jobDone)
+	}
+}
+
+func (j *job) finish(status string) {
+	j.mu.Lock()
+	j.status.Status = status
+	j.status.FinishedAt = time.Now().UnixMilli()
+	j.mu.Unlock()
+	j.cancel()
+}
+
+func (j *job) snapshot() jobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := j.status
+	s.Errors = append([]jobError{}, j.status.Errors...)
+	return s
+}
+
+func (j *job) canceled() error {
+	if j == nil {
+		return nil
+	}
+	return j.ctx.Err()
+}
+
+func (j *job) addError(path string, msg string) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.status.Errors = append(j.status.Errors, jobError{Path: path, Message: msg})
+	j.mu.Unlock()
+}
+
+func (j *job) addTotal(bytes, files int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.status.TotalBytes += bytes
+	j.status.TotalFiles += files
+	j.mu.Unlock()
+}
+
+func (j *job) addDone(bytes, files int64) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.status.DoneBytes += bytes
+	j.status.DoneFiles += files
+	j.mu.Unlock()
+}
+
+// complete records that the job produced its result, so errors added so far
+// only concern the entries that were left out.
+func (j *job) complete() {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.completed = true
+	j.mu.Unlock()
+}
+
+func (j *job) isCompleted() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.completed
+}
+
+func (j *job) errorCount() int {
+	if j == nil {
+		return 0
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return len(j.status.Errors)
+}
+
+// measure walks the given paths to fill in the totals shown as progress.
+func (j *job) measure(paths []string) {
+	for _, p := range paths {
+		if j.canceled() != nil {
+			return
+		}
+		j.addTotal(treeSize(p))
+	}
+}
+
+func treeSize(p string) (bytes, files int64) {
+	_ = filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		bytes += info.Size()
+		files++
+		return nil
+	})
+	return bytes, files
+}
+
+// jobReader counts copied bytes and aborts the copy once the job is canceled.
+type jobReader struct {
+	j *job
+	r io.Reader
+}
+
+func (r *jobReader) Read(p []byte) (int, error) {
+	if err := r.j.canceled(); err != nil {
+		return 0, err
+	}
+	n, err := r.r.Read(p)
+	r.j.addDone(int64(n), 0)
+	return n, err
+}
+
+func listJobs(c echo.Context) error {
+	return c.JSON(http.StatusOK, jobs.list(middlewares.CurrentUser(c)))
+}
+
+func getJob(c echo.Context) error {
+	j := jobs.get(middlewares.CurrentUser(c), c.Param("id"))
+	if j == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Job not found"})
+	}
+	return c.JSON(http.StatusOK, j.snapshot())
+}
+
+func cancelJob(c echo.Context) error {
+	j := jobs.get(middlewares.CurrentUser(c), c.Param("id"))
+	if j == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Job not found"})
+	}
+	j.mu.Lock()
+	queued := j.status.Status == jobQueued
+	j.mu.Unlock()
+	if queued {
+		j.finish(jobCanceled)
+	} else {
+		j.cancel()
+	}
+	return c.JSON(http.StatusOK, j.snapshot())
+}
@@ -0,0 +1,277 @@
+package routes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/types"
+)
+
+const (
+	listDefaultLimit = 500
+	listMaxLimit     = 5000
+)
+
+// listQuery holds the sorting, filtering and paging options of getFiles.
+// Without limit or cursor the whole directory is returned as a plain array.
+type listQuery struct {
+	sort   string
+	desc   bool
+	hidden bool
+	exts   map[string]bool
+	detail bool
+	paged  bool
+	limit  int
+	after  *listKey
+}
+
+// listItem is a directory entry on its way into a listing; entry is only
+// filled in once statItems has run on it.
+type listItem struct {
+	d     os.DirEntry
+	entry types.Entry
+}
+
+// listKey orders items: by i, then s, then name. It doubles as the cursor,
+// so a page continues after the last item sent even if entries were added
+// or removed in between.
+type listKey struct {
+	I int64  `json:"i,omitempty"`
+	S string `json:"s,omitempty"`
+	N string `json:"n"`
+}
+
+func parseListQuery(c echo.Context) (*listQuery, error) {
+	q := &listQuery{sort: c.QueryParam("sort"), hidden: true}
+	switch q.sort {
+	case "", "name", "size", "mtime", "type":
+	default:
+		return nil, fmtError("Unknown sort: %s", q.sort)
+	}
+	switch order := c.QueryParam("order"); order {
+	case "", "asc":
+	case "desc":
+		q.desc = true
+	default:
+		return nil, fmtError("Unknown order: %s", order)
+	}
+	if s := c.QueryParam("hidden"); s != "" {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmtError("Invalid hidden")
+		}
+		q.hidden = b
+	}
+	q.exts = parseExtList(c.QueryParam("ext"))
+	// Owner names and statx cost extra lookups per entry, so they are opt-in.
+	q.detail, _ = strconv.ParseBool(c.QueryParam("detail"))
+
+	limit, cursor := c.QueryParam("limit"), c.QueryParam("cursor")
+	if limit == "" && cursor == "" {
+		return q, nil
+	}
+	q.paged = true
+	if q.sort == "" {
+		q.sort = "name"
+	}
+	q.limit = listDefaultLimit
+	if limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return nil, fmtError("Invalid limit")
+		}
+		q.limit = n
+	}
+	if q.limit > listMaxLimit {
+		q.limit = listMaxLimit
+	}
+	if cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, fmtError("Invalid cursor")
+		}
+		q.after = &listKey{}
+		if err := json.Unmarshal(b, q.after); err != nil {
+			return nil, fmtError("Invalid cursor")
+		}
+	}
+	return q, nil
+}
+
+// isPagedList reports whether a /list request asks for a page.
+func isPagedList(c echo.Context) bool {
+	return c.QueryParam("limit") != "" || c.QueryParam("cursor") != ""
+}
+
+// skipIfPaged bypasses mw for paged listings, which are streamed with an ETag
+// of their own rather than buffered and hashed by the etag middleware.
+func skipIfPaged(mw echo.MiddlewareFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		wrapped := mw(next)
+		return func(c echo.Context) error {
+			if isPagedList(c) {
+				return next(c)
+			}
+			return wrapped(c)
+		}
+	}
+}
+
+// filter applies the options that only need names. Directories are kept
+// regardless of ext so the listing can still be navigated.
+func (q *listQuery) filter(entries []os.DirEntry) []listItem {
+	items := make([]listItem, 0, len(entries))
+	for _, e := range entries {
+		name := e.Name()
+		if !q.hidden && strings.HasPrefix(name, ".") {
+			continue
+		}
+		if q.exts != nil && !e.IsDir() && !q.exts[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		items = append(items, listItem{d: e})
+	}
+	return items
+}
+
+// needsStat reports whether sorting needs every item stat'ed first.
+func (q *listQuery) needsStat() bool { return q.sort == "size" || q.sort == "mtime" }
+
+func (q *listQuery) key(it *listItem) listKey {
+	name := it.d.Name()
+	switch q.sort {
+	case "name":
+		return listKey{S: strings.ToLower(name), N: name}
+	case "type":
+		// Directories first, then files grouped by extension.
+		if it.d.IsDir() {
+			return listKey{N: name}
+		}
+		return listKey{I: 1, S: strings.ToLower(filepath.Ext(name)), N: name}
+	case "size":
+		if it.entry.IsDirectory || it.entry.Size == nil {
+			return listKey{I: -1, N: name}
+		}
+		return listKey{I: *it.entry.Size, N: name}
+	case "mtime":
+		return listKey{I: it.entry.LastModified, N: name}
+	}
+	return listKey{N: name}
+}
+
+func (q *listQuery) less(a, b listKey) bool {
+	if q.desc {
+		a, b = b, a
+	}
+	if a.I != b.I {
+		return a.I < b.I
+	}
+	if a.S != b.S {
+		return a.S < b.S
+	}
+	return a.N < b.N
+}
+
+// sortItems leaves items in ReadDir order when no sort was asked for.
+func (q *listQuery) sortItems(items []listItem) {
+	if q.sort == "" {
+		return
+	}
+	keys := make(map[string]listKey, len(items))
+	for i := range items {
+		keys[items[i].d.Name()] = q.key(&items[i])
+	}
+	sort.Slice(items, func(a, b int) bool {
+		return q.less(keys[items[a].d.Name()], keys[items[b].d.Name()])
+	})
+}
+
+// page returns the items after the cursor and the cursor for the next page,
+// or "" if this is the last one.
+func (q *listQuery) page(items []listItem) ([]listItem, string) {
+	start := 0
+	if q.after != nil {
+		start = sort.Search(len(items), func(i int) bool { return q.less(*q.after, q.key(&items[i])) })
+	}
+	end := start + q.limit
+	if end >= len(items) {
+		return items[start:], ""
+	}
+	b, _ := json.Marshal(q.key(&items[end-1]))
+	return items[start:end], base64.RawURLEncoding.EncodeToString(b)
+}
+
+// statItems fills in the entries of items using readDirStatConcurrency workers.
+func statItems(root, dir string, items []listItem, detail bool) {
+	jobs := make(chan int)
+	workerCount := readDirStatConcurrency
+	if len(items) < workerCount {
+		workerCount = len(items)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < workerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				items[i].entry = listEntry(root, dir, items[i].d, detail)
+			}
+		}()
+	}
+	for i := range items {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
+
+// writeListPage streams {"total","nextCursor","items"} one entry at a time.
+// The ETag is a hash of the same content, so If-None-Match works per page and
+// changes whenever anything shown on the page does.
+func writeListPage(c echo.Context, total int, page []listItem, next string) error {
+	var nextJSON []byte
+	if next == "" {
+		nextJSON = []byte("null")
+	} else {
+		nextJSON, _ = json.Marshal(next)
+	}
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d\x00%s\x00", total, nextJSON)
+	enc := json.NewEncoder(h)
+	for i := range page {
+		_ = enc.Encode(page[i].entry)
+	}
+	tag := fmt.Sprintf(`W/"%x"`, h.Sum64())
+	if c.Request().Header.Get("If-None-Match") == tag {
+		c.Response().Header().Set("ETag", tag)
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	w := c.Response()
+	w.Header().Set("ETag", tag)
+	w.Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, `{"total":%d,"nextCursor":%s,"items":[`, total, nextJSON)
+	enc = json.NewEncoder(w)
+	for i := range page {
+		if i > 0 {
+			_, _ = w.Write([]byte(","))
+		}
+		if err := enc.Encode(page[i].entry); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write([]byte("]}"))
+	return err
+}
@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+// saveMaxSize bounds the body of POST /files/save; it is meant for text
+// edited in the browser, larger files go through the upload endpoints.
+const saveMaxSize = 32 << 20
+
+// pathLocks hands out one mutex per path. Entries are reference counted and
+// dropped on the last unlock, so paths that are renamed or deleted do not
+// pile up.
+type pathLocks struct {
+	mu sync.Mutex
+	m  map[string]*pathLock
+}
+
+type pathLock struct {
+	sync.Mutex
+	refs int
+}
+
+func (l *pathLocks) lock(p string) func() {
+	l.mu.Lock()
+	if l.m == nil {
+		l.m = map[string]*pathLock{}
+	}
+	pl := l.m[p]
+	if pl == nil {
+		pl = &pathLock{}
+		l.m[p] = pl
+	}
+	pl.refs++
+	l.mu.Unlock()
+
+	pl.Lock()
+	return func() {
+		pl.Unlock()
+		l.mu.Lock()
+		if pl.refs--; pl.refs == 0 {
+			delete(l.m, p)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// saveLocks serializes saves of the same file so the precondition check and
+// the rename cannot interleave with another save.
+var saveLocks pathLocks
+
+func lockSave(p string) func() { return saveLocks.lock(p) }
+
+// fileETag is a strong validator of a file built from its size and mtime, the
+// same one /files/stream sends.
+func fileETag(st os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, st.Size(), st.ModTime().UnixNano())
+}
+
+// etagMatches reports whether an If-Match style header value lists etag.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSavePrecondition compares the file as it is now (st is nil if it does
+// not exist) with what the client read: an If-Match ETag (or, without one,
+// If-Unmodified-Since), an mtime query parameter in milliseconds, or
+// If-None-Match: * to only create new files.
+func checkSavePrecondition(c echo.Context, st os.FileInfo) bool {
+	if m := c.Request().Header.Get("If-Match"); m != "" {
+		if st == nil || !etagMatches(m, fileETag(st)) {
+			return false
+		}
+	} else if s := c.Request().Header.Get("If-Unmodified-Since"); s != "" {
+		// HTTP dates have whole seconds; one that does not parse is ignored.
+		if t, err := http.ParseTime(s); err == nil && (st == nil || st.ModTime().Truncate(time.Second).After(t)) {
+			return false
+		}
+	}
+	if c.Request().Header.Get("If-None-Match") == "*" && st != nil {
+		return false
+	}
+	if s := c.QueryParam("mtime"); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || st == nil || st.ModTime().UnixMilli() != ms {
+			return false
+		}
+	}
+	return true
+}
+
+// saveFile writes the request body to path, creating the file or replacing
+// it atomically. An existing file keeps its mode and, where possible, owner;
+// a symlink is resolved so the link itself stays in place. When the file was
+// changed since the client read it the save is refused with 412.
+func saveFile(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	target := path
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		if !isPathSafe(c, resolved) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+		}
+		target = resolved
+	}
+	if st, err := os.Stat(filepath.Dir(target)); err != nil || !st.IsDir() {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Directory not found"})
+	}
+	if c.Request().ContentLength > saveMaxSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": "File is too large to save"})
+	}
+
+	unlock := lockSave(target)
+	defer unlock()
+	var orig os.FileInfo
+	perm := os.FileMode(0644)
+	if st, err := os.Stat(target); err == nil {
+		if !st.Mode().IsRegular() {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a file"})
+		}
+		orig, perm = st, st.Mode().Perm()
+	}
+	if !checkSavePrecondition(c, orig) {
+		res := map[string]any{"message": "File was changed by someone else"}
+		if orig != nil {
+			res["etag"] = fileETag(orig)
+			res["lastModified"] = orig.ModTime().UnixMilli()
+		}
+		return c.JSON(http.StatusPreconditionFailed, res)
+	}
+
+	// The old content is only versioned once the new one has fully arrived,
+	// so an aborted or oversized save leaves no snapshot behind.
+	body := http.MaxBytesReader(c.Response(), c.Request().Body, saveMaxSize)
+	var snapErr error
+	size, err := utils.WriteFileAtomic(target, body, perm, orig, func() error {
+		snapErr = snapshotVersion(target, middlewares.CurrentUser(c).Username)
+		return snapErr
+	})
+	if snapErr != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to keep the previous version"})
+	}
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"message": "File is too large to save"})
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) || c.Request().Context().Err() != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	st, err := os.Stat(target)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	status := http.StatusOK
+	if orig == nil {
+		status = http.StatusCreated
+	}
+	c.Response().Header().Set("ETag", fileETag(st))
+	return c.JSON(status, map[string]any{
+		"path":         path,
+		"size":         size,
+		"etag":         fileETag(st),
+		"lastModified": st.ModTime().UnixMilli(),
+	})
+}
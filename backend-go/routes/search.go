@@ -0,0 +1,278 @@
+package routes
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/middlewares"
+	"file-lite-go/types"
+)
+
+const (
+	searchDefaultLimit = 1000
+	searchMaxLimit     = 10000
+)
+
+// searchHit is one line of the NDJSON search stream.
+type searchHit struct {
+	Path string `json:"path"`
+	types.Entry
+}
+
+type searchFilter struct {
+	match          func(name string) bool
+	exts           map[string]bool
+	typ            string
+	minSize        int64
+	maxSize        int64
+	modifiedAfter  int64
+	modifiedBefore int64
+}
+
+func parseSearchFilter(c echo.Context) (*searchFilter, error) {
+	f := &searchFilter{minSize: -1, maxSize: -1, typ: c.QueryParam("type")}
+	if f.typ != "" && f.typ != "file" && f.typ != "dir" {
+		return nil, fmtError("Unknown type: %s", f.typ)
+	}
+
+	q := c.QueryParam("q")
+	switch mode := c.QueryParam("mode"); mode {
+	case "", "substring":
+		lq := strings.ToLower(q)
+		f.match = func(name string) bool { return strings.Contains(strings.ToLower(name), lq) }
+	case "glob":
+		if _, err := filepath.Match(q, ""); err != nil {
+			return nil, fmtError("Invalid glob: %s", q)
+		}
+		lq := strings.ToLower(q)
+		f.match = func(name string) bool {
+			ok, _ := filepath.Match(lq, strings.ToLower(name))
+			return ok
+		}
+	case "regex":
+		re, err := regexp.Compile(q)
+		if err != nil {
+			return nil, fmtError("Invalid regex: %s", err.Error())
+		}
+		f.match = re.MatchString
+	default:
+		return nil, fmtError("Unknown mode: %s", mode)
+	}
+
+	f.exts = parseExtList(c.QueryParam("ext"))
+
+	ints := []struct {
+		name string
+		dst  *int64
+	}{
+		{"minSize", &f.minSize},
+		{"maxSize", &f.maxSize},
+		{"modifiedAfter", &f.modifiedAfter},
+		{"modifiedBefore", &f.modifiedBefore},
+	}
+	for _, p := range ints {
+		s := c.QueryParam(p.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, fmtError("Invalid %s", p.name)
+		}
+		*p.dst = n
+	}
+	return f, nil
+}
+
+// parseExtList turns "jpg,.PNG" into a set of lower case extensions with the
+// leading dot, or nil when s is empty.
+func parseExtList(s string) map[string]bool {
+	if s == "" {
+		return nil
+	}
+	exts := map[string]bool{}
+	for _, e := range strings.Split(s, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if e == "" {
+			continue
+		}
+		if !strings.HasPrefix(e, ".") {
+			e = "." + e
+		}
+		exts[e] = true
+	}
+	return exts
+}
+
+// matchName applies the filters that need no stat call.
+func (f *searchFilter) matchName(e os.DirEntry) bool {
+	if f.typ == "file" && e.IsDir() || f.typ == "dir" && !e.IsDir() {
+		return false
+	}
+	if f.exts != nil && (e.IsDir() || !f.exts[strings.ToLower(filepath.Ext(e.Name()))]) {
+		return false
+	}
+	return f.match(e.Name())
+}
+
+func (f *searchFilter) matchEntry(e types.Entry) bool {
+	if e.Error != nil {
+		return false
+	}
+	if (f.minSize >= 0 || f.maxSize >= 0) && e.IsDirectory {
+		return false
+	}
+	if f.minSize >= 0 && *e.Size < f.minSize {
+		return false
+	}
+	if f.maxSize >= 0 && *e.Size > f.maxSize {
+		return false
+	}
+	mt := e.LastModified
+	if f.modifiedAfter > 0 && mt < f.modifiedAfter {
+		return false
+	}
+	if f.modifiedBefore > 0 && mt > f.modifiedBefore {
+		return false
+	}
+	return true
+}
+
+// walkSearch walks root breadth-first and sends every entry that passes the
+// filter. Candidates are stat'ed by readDirStatConcurrency workers, like
+// getFiles does, and symlinks are described as listEntry does for sandbox.
+// Symlinked directories are not followed.
+func walkSearch(ctx context.Context, sandbox, root string, f *searchFilter, hits chan<- searchHit) {
+	type statJob struct {
+		dir   string
+		entry os.DirEntry
+	}
+	jobs := make(chan statJob)
+	var wg sync.WaitGroup
+	for i := 0; i < readDirStatConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range jobs {
+				p := filepath.Join(job.dir, job.entry.Name())
+				entry := listEntry(sandbox, job.dir, job.entry, false)
+				if !f.matchEntry(entry) {
+					continue
+				}
+				select {
+				case hits <- searchHit{Path: p, Entry: entry}:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+
+	queue := []string{root}
+walk:
+	for len(queue) > 0 {
+		dir := queue[0]
+		queue = queue[1:]
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				queue = append(queue, filepath.Join(dir, e.Name()))
+			}
+			if !f.matchName(e) {
+				continue
+			}
+			select {
+			case jobs <- statJob{dir: dir, entry: e}:
+			case <-ctx.Done():
+				break walk
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(hits)
+}
+
+// searchFiles streams matches as NDJSON, one searchHit per line, and ends with
+// a {"done":true,"count":n,"truncated":bool} line. The walk stops at the
+// result limit or when the client goes away.
+func searchFiles(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	if !st.IsDir() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a directory"})
+	}
+	f, err := parseSearchFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+	}
+	limit := searchDefaultLimit
+	if s := c.QueryParam("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid limit"})
+		}
+		limit = n
+	}
+	if limit > searchMaxLimit {
+		limit = searchMaxLimit
+	}
+
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	hits := make(chan searchHit, readDirStatConcurrency)
+	go walkSearch(ctx, middlewares.CurrentUser(c).Root, path, f, hits)
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "application/x-ndjson")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(res)
+
+	started := time.Now()
+	count, truncated := 0, false
+	for hit := range hits {
+		if count == limit {
+			truncated = true
+			cancel()
+			continue
+		}
+		if enc.Encode(hit) != nil {
+			cancel()
+			continue
+		}
+		count++
+		if len(hits) == 0 {
+			res.Flush()
+		}
+	}
+	if ctx.Err() != nil && !truncated {
+		// The client disconnected; nobody is listening for the summary.
+		return nil
+	}
+	_ = enc.Encode(map[string]any{"done": true, "count": count, "truncated": truncated, "elapsedMs": time.Since(started).Milliseconds()})
+	res.Flush()
+	return nil
+}
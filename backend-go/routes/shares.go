@@ -0,0 +1,476 @@
+package routes
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+// share is a public link to one file or directory. The ID is the secret part
+// of the URL; downloads through it do not need the master token.
+type share struct {
+	ID           string `json:"id"`
+	Path         string `json:"path"`
+	IsDirectory  bool   `json:"isDirectory"`
+	Owner        string `json:"owner"`
+	CreatedAt    int64  `json:"createdAt"`
+	ExpiresAt    int64  `json:"expiresAt"`
+	PasswordHash string `json:"passwordHash,omitempty"`
+	MaxDownloads int64  `json:"maxDownloads"`
+	Downloads    int64  `json:"downloads"`
+}
+
+type shareView struct {
+	ID               string `json:"id"`
+	Name             string `json:"name"`
+	Path             string `json:"path,omitempty"`
+	IsDirectory      bool   `json:"isDirectory"`
+	CreatedAt        int64  `json:"createdAt"`
+	ExpiresAt        int64  `json:"expiresAt"`
+	RequiresPassword bool   `json:"requiresPassword"`
+	MaxDownloads     int64  `json:"maxDownloads"`
+	Downloads        int64  `json:"downloads"`
+	URL              string `json:"url"`
+}
+
+type shareStore struct {
+	mu     sync.Mutex
+	loaded bool
+	shares map[string]*share
+}
+
+var shares = &shareStore{}
+
+// A download session lets the client that started a share download resume
+// and seek in it without using up the download limit again. The cookie is
+// an HMAC over the share ID and expiry, keyed per process, so sessions end
+// with a restart.
+const shareSessionTTL = 12 * time.Hour
+
+var shareSessionKey = func() []byte {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}()
+
+func registerShares(files *echo.Group, public *echo.Group) {
+	read := middlewares.RequirePermission(config.PermRead)
+	files.GET("/shares", func(c echo.Context) error { return listShares(c) })
+	files.POST("/shares", func(c echo.Context) error { return createShare(c) }, read)
+	files.DELETE("/shares/:id", func(c echo.Context) error { return deleteShare(c) })
+
+	public.GET("/:id", func(c echo.Context) error { return getSharedInfo(c) })
+	public.GET("/:id/list", func(c echo.Context) error { return listShared(c) })
+	public.GET("/:id/download", func(c echo.Context) error { return downloadShared(c) })
+	public.HEAD("/:id/download", func(c echo.Context) error { return downloadShared(c) })
+	public.POST("/:id/download", func(c echo.Context) error { return downloadShared(c) })
+}
+
+func sharesFilePath() string { return filepath.Join(config.DataBaseDir(), "shares.json") }
+
+func (s *shareStore) load() {
+	if s.loaded {
+		return
+	}
+	s.shares = map[string]*share{}
+	if b, err := os.ReadFile(sharesFilePath()); err == nil {
+		var list []*share
+		if json.Unmarshal(b, &list) == nil {
+			for _, sh := range list {
+				s.shares[sh.ID] = sh
+			}
+		}
+	}
+	s.loaded = true
+}
+
+func (s *shareStore) save() error {
+	list := make([]*share, 0, len(s.shares))
+	for _, sh := range s.shares {
+		list = append(list, sh)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt < list[b].CreatedAt })
+	b, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(config.DataBaseDir(), 0755); err != nil {
+		return err
+	}
+	tmp := sharesFilePath() + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, sharesFilePath())
+}
+
+// purgeExpired drops shares past their expiry time. Exhausted shares stay
+// listed so their owner can see that the limit was reached.
+func (s *shareStore) purgeExpired(now time.Time) bool {
+	changed := false
+	for id, sh := range s.shares {
+		if sh.ExpiresAt != 0 && now.UnixMilli() >= sh.ExpiresAt {
+			delete(s.shares, id)
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (sh *share) view(withPath bool) shareView {
+	v := shareView{
+		ID:               sh.ID,
+		Name:             filepath.Base(sh.Path),
+		IsDirectory:      sh.IsDirectory,
+		CreatedAt:        sh.CreatedAt,
+		ExpiresAt:        sh.ExpiresAt,
+		RequiresPassword: sh.PasswordHash != "",
+		MaxDownloads:     sh.MaxDownloads,
+		Downloads:        sh.Downloads,
+		URL:              "/api/share/" + sh.ID,
+	}
+	if withPath {
+		v.Path = sh.Path
+	}
+	return v
+}
+
+// ownerOf resolves the account a share was created by; shares of removed users stop working.
+func (sh *share) ownerOf() (config.User, bool) {
+	if sh.Owner == "" {
+		return config.AdminUser(), true
+	}
+	u := config.FindUser(sh.Owner)
+	if u == nil {
+		return config.User{}, false
+	}
+	return *u, true
+}
+
+func listShares(c echo.Context) error {
+	u := middlewares.CurrentUser(c)
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	shares.load()
+	if shares.purgeExpired(time.Now()) {
+		_ = shares.save()
+	}
+	list := []shareView{}
+	for _, sh := range shares.shares {
+		if u.Admin || sh.Owner == u.Username {
+			list = append(list, sh.view(true))
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt > list[b].CreatedAt })
+	return c.JSON(http.StatusOK, list)
+}
+
+func createShare(c echo.Context) error {
+	var body struct {
+		Path         string `json:"path"`
+		ExpiresAt    int64  `json:"expiresAt"`
+		Password     string `json:"password"`
+		MaxDownloads int64  `json:"maxDownloads"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if !isPathSafe(c, body.Path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(body.Path)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+	}
+	now := time.Now()
+	if body.ExpiresAt != 0 && body.ExpiresAt <= now.UnixMilli() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "expiresAt must be in the future"})
+	}
+	if body.MaxDownloads < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid maxDownloads"})
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	abs, _ := filepath.Abs(body.Path)
+	sh := &share{
+		ID:           id,
+		Path:         abs,
+		IsDirectory:  st.IsDir(),
+		Owner:        middlewares.CurrentUser(c).Username,
+		CreatedAt:    now.UnixMilli(),
+		ExpiresAt:    body.ExpiresAt,
+		MaxDownloads: body.MaxDownloads,
+	}
+	if body.Password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+		}
+		sh.PasswordHash = string(hash)
+	}
+
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	shares.load()
+	shares.purgeExpired(now)
+	shares.shares[id] = sh
+	if err := shares.save(); err != nil {
+		delete(shares.shares, id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	return c.JSON(http.StatusCreated, sh.view(true))
+}
+
+func deleteShare(c echo.Context) error {
+	u := middlewares.CurrentUser(c)
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	shares.load()
+	sh := shares.shares[c.Param("id")]
+	if sh == nil || !(u.Admin || sh.Owner == u.Username) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Share not found"})
+	}
+	delete(shares.shares, sh.ID)
+	if err := shares.save(); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// openShare looks up a live share and checks its password, which comes from
+// the X-Share-Password header or, for form posts, the "password" body field.
+// Never from the query string, where it would end up in logs and history.
+// On failure it has already written the response and returns nil.
+func openShare(c echo.Context) (*share, error) {
+	setShareSecurityHeaders(c)
+	if middlewares.CheckBanned(c) {
+		return nil, c.JSON(http.StatusForbidden, map[string]string{"message": "Forbidden"})
+	}
+	shares.mu.Lock()
+	shares.load()
+	sh := shares.shares[c.Param("id")]
+	var cp share
+	if sh != nil {
+		cp = *sh
+	}
+	shares.mu.Unlock()
+	if sh == nil || (cp.ExpiresAt != 0 && time.Now().UnixMilli() >= cp.ExpiresAt) {
+		return nil, c.JSON(http.StatusNotFound, map[string]string{"message": "Share not found"})
+	}
+	if cp.PasswordHash != "" {
+		password := c.Request().Header.Get("X-Share-Password")
+		if password == "" && c.Request().Method == http.MethodPost {
+			password = c.Request().PostFormValue("password")
+		}
+		if bcrypt.CompareHashAndPassword([]byte(cp.PasswordHash), []byte(password)) != nil {
+			if password != "" {
+				middlewares.RecordAuthFailure(c)
+			}
+			return nil, c.JSON(http.StatusUnauthorized, map[string]any{"message": "Password required", "requiresPassword": true})
+		}
+		middlewares.RecordAuthSuccess(c)
+	}
+	return &cp, nil
+}
+
+// resolveSharedPath maps the optional relative "path" query of a directory
+// share onto disk, keeping it inside both the share and its owner's sandbox.
+func resolveSharedPath(c echo.Context, sh *share) (string, bool) {
+	owner, ok := sh.ownerOf()
+	if !ok || !owner.Permissions.Allows(config.PermRead) {
+		return "", false
+	}
+	p := sh.Path
+	if rel := c.QueryParam("path"); rel != "" && sh.IsDirectory {
+		p = filepath.Join(sh.Path, filepath.FromSlash(strings.TrimLeft(rel, "/\\")))
+	}
+	if !isPathWithin(sh.Path, p) || !isPathWithin(owner.Root, p) {
+		return "", false
+	}
+	return p, true
+}
+
+func getSharedInfo(c echo.Context) error {
+	if middlewares.CheckBanned(c) {
+		return c.JSON(http.StatusForbidden, map[string]string{"message": "Forbidden"})
+	}
+	shares.mu.Lock()
+	shares.load()
+	sh := shares.shares[c.Param("id")]
+	var v shareView
+	if sh != nil {
+		v = sh.view(false)
+	}
+	shares.mu.Unlock()
+	if sh == nil || (v.ExpiresAt != 0 && time.Now().UnixMilli() >= v.ExpiresAt) {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Share not found"})
+	}
+	return c.JSON(http.StatusOK, v)
+}
+
+func listShared(c echo.Context) error {
+	sh, err := openShare(c)
+	if sh == nil {
+		return err
+	}
+	p, ok := resolveSharedPath(c, sh)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(p)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+	}
+	if !st.IsDir() {
+		entry := entryFromStat(st.Name(), st)
+		setBirthtime(&entry, p, st)
+		return c.JSON(http.StatusOK, []any{entry})
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to read directory"})
+	}
+	res := make([]any, 0, len(entries))
+	for _, e := range entries {
+		res = append(res, listEntry(sh.Path, p, e, false))
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// setShareSecurityHeaders keeps shared content from running in the origin of
+// the app: whatever the file is, the browser renders it sandboxed and takes
+// its declared type at face value.
+func setShareSecurityHeaders(c echo.Context) {
+	h := c.Response().Header()
+	h.Set("Content-Security-Policy", "sandbox")
+	h.Set("X-Content-Type-Options", "nosniff")
+}
+
+// isActiveContentType reports types a browser would run script from when
+// shown inline.
+func isActiveContentType(t string) bool {
+	t, _, _ = strings.Cut(t, ";")
+	t = strings.ToLower(strings.TrimSpace(t))
+	return t == "text/html" || t == "application/xhtml+xml" || t == "image/svg+xml" ||
+		t == "text/xml" || t == "application/xml" || strings.HasSuffix(t, "+xml")
+}
+
+func shareSessionCookieName(id string) string { return "file_lite_share_" + id }
+
+func shareSessionMAC(id string, exp int64) string {
+	m := hmac.New(sha256.New, shareSessionKey)
+	m.Write([]byte(id + "\x00" + strconv.FormatInt(exp, 10)))
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func hasShareSession(c echo.Context, id string) bool {
+	ck, err := c.Cookie(shareSessionCookieName(id))
+	if err != nil {
+		return false
+	}
+	expStr, mac, ok := strings.Cut(ck.Value, ".")
+	if !ok {
+		return false
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil || time.Now().Unix() >= exp {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(shareSessionMAC(id, exp)))
+}
+
+func startShareSession(c echo.Context, id string) {
+	exp := time.Now().Add(shareSessionTTL).Unix()
+	c.SetCookie(&http.Cookie{
+		Name:     shareSessionCookieName(id),
+		Value:    strconv.FormatInt(exp, 10) + "." + shareSessionMAC(id, exp),
+		Path:     "/api/share/" + id,
+		MaxAge:   int(shareSessionTTL / time.Second),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// countShareDownload reserves one download against the share limit. A
+// download counts once per session: the first GET counts and starts the
+// session, later GETs carrying its cookie (Range requests while seeking or
+// resuming) are free. HEAD never counts.
+func countShareDownload(c echo.Context, id string) bool {
+	if c.Request().Method == http.MethodHead || hasShareSession(c, id) {
+		return true
+	}
+	shares.mu.Lock()
+	defer shares.mu.Unlock()
+	sh := shares.shares[id]
+	if sh == nil || (sh.MaxDownloads > 0 && sh.Downloads >= sh.MaxDownloads) {
+		return false
+	}
+	sh.Downloads++
+	_ = shares.save()
+	startShareSession(c, id)
+	return true
+}
+
+func downloadShared(c echo.Context) error {
+	sh, err := openShare(c)
+	if sh == nil {
+		return err
+	}
+	p, ok := resolveSharedPath(c, sh)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(p)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+	}
+	if st.IsDir() {
+		owner, _ := sh.ownerOf()
+		if !owner.Permissions.Allows(config.PermDownloadZip) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Permission denied: " + config.PermDownloadZip})
+		}
+	}
+	if !countShareDownload(c, sh.ID) {
+		return c.JSON(http.StatusGone, map[string]string{"message": "Download limit reached"})
+	}
+	if st.IsDir() {
+		return downloadMulti([]string{p}, "zip", sh.Path, c)
+	}
+	name := filepath.Base(p)
+	// Declare the type rather than let the file server sniff it from the
+	// content, and never show markup inline.
+	t := mime.TypeByExtension(filepath.Ext(name))
+	if t == "" {
+		t = "application/octet-stream"
+	}
+	h := c.Response().Header()
+	h.Set("Content-Type", t)
+	if c.QueryParam("inline") != "" && !isActiveContentType(t) {
+		h.Set("Content-Disposition", utils.InlineDisposition(name))
+	} else {
+		h.Set("Content-Disposition", utils.AttachmentDisposition(name))
+	}
+	return c.File(p)
+}
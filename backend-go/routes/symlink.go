@@ -0,0 +1,124 @@
+package routes
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"file-lite-go/types"
+)
+
+const maxSymlinkHops = 40
+
+var errTooManySymlinks = errors.New("too many levels of symbolic links")
+
+// resolvePath is filepath.EvalSymlinks for paths that may not exist yet: the
+// missing tail is appended to the resolved existing part. A dangling symlink is
+// resolved to where it points, since creating the path would create that.
+func resolvePath(p string) (string, error) {
+	return resolvePathHops(p, 0)
+}
+
+func resolvePathHops(p string, hops int) (string, error) {
+	if hops > maxSymlinkHops {
+		return "", errTooManySymlinks
+	}
+	r, err := filepath.EvalSymlinks(p)
+	if err == nil {
+		return r, nil
+	}
+	if !os.IsNotExist(err) {
+		return "", err
+	}
+	if st, lerr := os.Lstat(p); lerr == nil && st.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(p), target)
+		}
+		return resolvePathHops(target, hops+1)
+	}
+	parent := filepath.Dir(p)
+	if parent == p {
+		return p, nil
+	}
+	rp, err := resolvePathHops(parent, hops)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(rp, filepath.Base(p)), nil
+}
+
+// hasSymlinkBelow reports whether any existing component of p below base is a symlink.
+func hasSymlinkBelow(base, p string) bool {
+	rel, err := filepath.Rel(base, p)
+	if err != nil || rel == "." {
+		return false
+	}
+	cur := base
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		st, err := os.Lstat(cur)
+		if err != nil {
+			return false
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// isEntryWithin is isPathWithin for operations on p itself rather than on what
+// it points to, such as rename and delete: a symlink may be removed or renamed
+// even when its target is off limits, as long as its directory is not.
+func isEntryWithin(base, p string) bool {
+	if isPathWithin(base, p) {
+		return true
+	}
+	if base == "" || p == "" {
+		return false
+	}
+	rp, err := filepath.Abs(p)
+	if err != nil {
+		return false
+	}
+	bp, err := filepath.Abs(base)
+	if err != nil {
+		return false
+	}
+	return rp != bp && isLexicallyWithin(bp, rp) && isPathWithin(base, filepath.Dir(rp))
+}
+
+// listEntry describes dir/e for a listing. A symlink is reported with its
+// target, and shows the metadata of what it points to only when the symlink
+// policy lets root follow it. detail adds the fields of addEntryDetail.
+func listEntry(root, dir string, e os.DirEntry, detail bool) types.Entry {
+	p := filepath.Join(dir, e.Name())
+	st, err := os.Lstat(p)
+	if err != nil {
+		return entryFromStatError(e, err)
+	}
+	isLink := st.Mode()&os.ModeSymlink != 0
+	var entry types.Entry
+	if isLink && isPathWithin(root, p) {
+		st, err = os.Stat(p)
+	}
+	if err != nil {
+		entry = entryFromStatError(e, err)
+	} else {
+		entry = entryFromStat(e.Name(), st)
+		setBirthtime(&entry, p, st)
+		if detail {
+			addEntryDetail(&entry, p, st)
+		}
+	}
+	if isLink {
+		entry.IsSymlink = true
+		entry.LinkTarget, _ = os.Readlink(p)
+	}
+	return entry
+}
@@ -0,0 +1,190 @@
+package routes
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/utils"
+)
+
+const (
+	thumbnailDefaultSize = 256
+	thumbnailMinSize     = 16
+	thumbnailMaxSize     = 1024
+
+	// The disk cache is least recently used first: hits bump a file's mtime,
+	// and once the cache outgrows thumbnailCacheMaxBytes the oldest files go
+	// until it is back under thumbnailCacheLowBytes.
+	thumbnailCacheMaxBytes = 512 << 20
+	thumbnailCacheLowBytes = thumbnailCacheMaxBytes * 9 / 10
+)
+
+// thumbnailSlots bounds how many images are decoded at once; decoding is CPU and memory heavy.
+var thumbnailSlots = make(chan struct{}, runtime.NumCPU())
+
+// thumbnailCache tracks the size of the disk cache, counted from the
+// directory the first time a thumbnail is added.
+var thumbnailCache struct {
+	mu     sync.Mutex
+	loaded bool
+	size   int64
+}
+
+func thumbnailCacheDir() string { return filepath.Join(config.DataBaseDir(), "thumbnails") }
+
+// touchThumbnail marks a cached thumbnail as recently used.
+func touchThumbnail(p string) {
+	now := time.Now()
+	_ = os.Chtimes(p, now, now)
+}
+
+// addThumbnail accounts for a newly cached thumbnail and evicts the least
+// recently used ones when the cache is over its cap.
+func addThumbnail(size int64) {
+	thumbnailCache.mu.Lock()
+	defer thumbnailCache.mu.Unlock()
+	if !thumbnailCache.loaded {
+		thumbnailCache.loaded = true
+		thumbnailCache.size = 0
+		for _, f := range readThumbnailCache() {
+			thumbnailCache.size += f.Size()
+		}
+	} else {
+		thumbnailCache.size += size
+	}
+	if thumbnailCache.size <= thumbnailCacheMaxBytes {
+		return
+	}
+	files := readThumbnailCache()
+	sort.Slice(files, func(a, b int) bool { return files[a].ModTime().Before(files[b].ModTime()) })
+	var total int64
+	for _, f := range files {
+		total += f.Size()
+	}
+	for _, f := range files {
+		if total <= thumbnailCacheLowBytes {
+			break
+		}
+		if os.Remove(filepath.Join(thumbnailCacheDir(), f.Name())) == nil {
+			total -= f.Size()
+		}
+	}
+	thumbnailCache.size = total
+}
+
+// readThumbnailCache lists the cached thumbnails, leaving out files still
+// being written.
+func readThumbnailCache() []os.FileInfo {
+	entries, err := os.ReadDir(thumbnailCacheDir())
+	if err != nil {
+		return nil
+	}
+	var files []os.FileInfo
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			files = append(files, info)
+		}
+	}
+	return files
+}
+
+func getThumbnail(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	size := thumbnailDefaultSize
+	if s := c.QueryParam("size"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid size"})
+		}
+		size = n
+	}
+	if size < thumbnailMinSize {
+		size = thumbnailMinSize
+	} else if size > thumbnailMaxSize {
+		size = thumbnailMaxSize
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+	}
+	if st.IsDir() || !utils.IsThumbnailable(st.Name()) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Not a supported image"})
+	}
+
+	abs, _ := filepath.Abs(path)
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d\x00%d", abs, st.ModTime().UnixNano(), st.Size(), size)))
+	key := hex.EncodeToString(sum[:])
+	etag := `"` + key[:32] + `"`
+	h := c.Response().Header()
+	h.Set("ETag", etag)
+	// The URL does not change when the file does, so let the browser revalidate with the ETag.
+	h.Set("Cache-Control", "private, max-age=60, stale-while-revalidate=86400")
+	if match := c.Request().Header.Get("If-None-Match"); match != "" && strings.Contains(match, etag) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	for _, ext := range []string{".jpg", ".png"} {
+		if cached := filepath.Join(thumbnailCacheDir(), key+ext); utils.FileExists(cached) {
+			touchThumbnail(cached)
+			return c.File(cached)
+		}
+	}
+
+	select {
+	case thumbnailSlots <- struct{}{}:
+	case <-c.Request().Context().Done():
+		return nil
+	}
+	defer func() { <-thumbnailSlots }()
+
+	if err := os.MkdirAll(thumbnailCacheDir(), 0755); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	tmp, err := os.CreateTemp(thumbnailCacheDir(), key+"-*.tmp")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	defer os.Remove(tmp.Name())
+	contentType, err := utils.Thumbnail(path, size, tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if errors.Is(err, utils.ErrImageTooLarge) {
+			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"message": "Image is too large to thumbnail"})
+		}
+		return c.JSON(http.StatusUnprocessableEntity, map[string]string{"message": "Failed to decode image: " + err.Error()})
+	}
+	ext := ".jpg"
+	if contentType == "image/png" {
+		ext = ".png"
+	}
+	cached := filepath.Join(thumbnailCacheDir(), key+ext)
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	if st, err := os.Stat(cached); err == nil {
+		addThumbnail(st.Size())
+	}
+	return c.File(cached)
+}
@@ -0,0 +1,399 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+// Deleted entries are renamed into DATA_BASE_DIR/trash/files/<id>. When that
+// rename would cross filesystems they go to a ".trash" directory at the top of
+// the entry's volume (bounded by the user's sandbox) instead. Metadata for every
+// item lives in DATA_BASE_DIR/trash/info/<id>.json either way.
+const (
+	volumeTrashDirName = ".trash"
+	trashPurgeEvery    = time.Hour
+)
+
+var trashIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type trashItem struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	OriginalPath string `json:"originalPath"`
+	Location     string `json:"location"`
+	IsDirectory  bool   `json:"isDirectory"`
+	Size         int64  `json:"size"`
+	DeletedAt    int64  `json:"deletedAt"`
+	Owner        string `json:"owner"`
+}
+
+// trashMu serializes moves in and out of the trash and quota enforcement.
+// trashRestoring holds the items a restore is about to take back out, so
+// that trashing the entry they replace cannot evict them first.
+var trashMu sync.Mutex
+var trashRestoring = map[string]bool{}
+var trashPurgeOnce sync.Once
+
+func registerTrash(g *echo.Group) {
+	g.GET("/trash", func(c echo.Context) error { return listTrash(c) }, middlewares.RequirePermission(config.PermRead))
+	g.POST("/trash/restore", func(c echo.Context) error { return restoreTrash(c) }, middlewares.RequirePermission(config.PermUpload))
+	g.POST("/trash/purge", func(c echo.Context) error { return purgeTrash(c) }, middlewares.RequirePermission(config.PermDelete))
+
+	trashPurgeOnce.Do(func() {
+		go func() {
+			for {
+				trashMu.Lock()
+				enforceTrashLimits(time.Now())
+				trashMu.Unlock()
+				time.Sleep(trashPurgeEvery)
+			}
+		}()
+	})
+}
+
+func trashDir() string               { return filepath.Join(config.DataBaseDir(), "trash") }
+func trashFilesDir() string          { return filepath.Join(trashDir(), "files") }
+func trashInfoDir() string           { return filepath.Join(trashDir(), "info") }
+func trashInfoPath(id string) string { return filepath.Join(trashInfoDir(), id+".json") }
+
+// volumeTrashDir returns the ".trash" directory for p: the highest ancestor of p
+// that is on the same device and still inside root.
+func volumeTrashDir(root, p string) string {
+	top := filepath.Dir(p)
+	for {
+		parent := filepath.Dir(top)
+		if parent == top || !isPathWithin(root, parent) || !utils.SameDevice(parent, p) {
+			break
+		}
+		top = parent
+	}
+	return filepath.Join(top, volumeTrashDirName)
+}
+
+func writeTrashItem(item *trashItem) error {
+	b, err := json.MarshalIndent(item, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := trashInfoPath(item.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, trashInfoPath(item.ID))
+}
+
+func readTrashItem(id string) (*trashItem, error) {
+	if !trashIDRe.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	b, err := os.ReadFile(trashInfoPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var item trashItem
+	if err := json.Unmarshal(b, &item); err != nil {
+		return nil, err
+	}
+	return &item, nil
+}
+
+func readTrashItems() []*trashItem {
+	entries, err := os.ReadDir(trashInfoDir())
+	if err != nil {
+		return nil
+	}
+	var items []*trashItem
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		if item, err := readTrashItem(id); err == nil {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(a, b int) bool { return items[a].DeletedAt > items[b].DeletedAt })
+	return items
+}
+
+func removeTrashItem(item *trashItem) error {
+	if err := os.RemoveAll(item.Location); err != nil {
+		return err
+	}
+	return os.Remove(trashInfoPath(item.ID))
+}
+
+// moveToTrash renames p into the trash and records where it came from. An
+// entry larger than the whole trash is refused rather than evicting
+// everything else; making room for it only evicts the owner's own older items.
+func moveToTrash(owner config.User, p string) error {
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return err
+	}
+	st, err := os.Lstat(abs)
+	if err != nil {
+		return err
+	}
+	if isPathWithin(trashDir(), abs) {
+		return errors.New("Path is already in the trash")
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+	size, _ := treeSize(abs)
+	if limit := config.Config().TrashMaxSizeMB; limit > 0 && size > limit<<20 {
+		return fmtError("%s is larger than the trash limit of %d MB; delete it permanently instead", filepath.Base(abs), limit)
+	}
+	item := &trashItem{
+		ID:           id,
+		Name:         filepath.Base(abs),
+		OriginalPath: abs,
+		IsDirectory:  st.IsDir(),
+		Size:         size,
+		DeletedAt:    time.Now().UnixMilli(),
+		Owner:        owner.Username,
+	}
+
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	if err := os.MkdirAll(trashFilesDir(), 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(trashInfoDir(), 0755); err != nil {
+		return err
+	}
+	item.Location = filepath.Join(trashFilesDir(), id)
+	if err := os.Rename(abs, item.Location); err != nil {
+		vt := volumeTrashDir(owner.Root, abs)
+		if isPathWithin(abs, vt) {
+			return err
+		}
+		if mkErr := os.MkdirAll(vt, 0755); mkErr != nil {
+			return err
+		}
+		item.Location = filepath.Join(vt, id)
+		if err := os.Rename(abs, item.Location); err != nil {
+			return err
+		}
+	}
+	if err := writeTrashItem(item); err != nil {
+		// Without metadata the item could never be restored; put it back.
+		_ = os.Rename(item.Location, abs)
+		return err
+	}
+	evictTrash(readTrashItems(), func(old *trashItem) bool {
+		return old.Owner == owner.Username && old.ID != item.ID
+	})
+	return nil
+}
+
+// evictTrash removes the oldest items that may go until the trash fits in
+// trashMaxSizeMB again, logging each one. items is newest first. Callers
+// hold trashMu.
+func evictTrash(items []*trashItem, mayEvict func(*trashItem) bool) {
+	limit := config.Config().TrashMaxSizeMB << 20
+	if limit <= 0 {
+		return
+	}
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	for i := len(items) - 1; i >= 0 && total > limit; i-- {
+		item := items[i]
+		if trashRestoring[item.ID] || !mayEvict(item) {
+			continue
+		}
+		if err := removeTrashItem(item); err != nil {
+			log.Printf("trash: failed to evict %s (%s): %v", item.ID, item.OriginalPath, err)
+			continue
+		}
+		total -= item.Size
+		log.Printf("trash: evicted %s (%s, %d bytes, owner %q) to stay under trashMaxSizeMB", item.ID, item.OriginalPath, item.Size, item.Owner)
+	}
+}
+
+// enforceTrashLimits is the background purge: it drops items older than
+// trashMaxAgeDays, then the oldest items of any owner until the trash fits in
+// trashMaxSizeMB. Zero disables either limit.
+func enforceTrashLimits(now time.Time) {
+	cfg := config.Config()
+	var kept []*trashItem
+	for _, item := range readTrashItems() {
+		if cfg.TrashMaxAgeDays > 0 && now.Sub(time.UnixMilli(item.DeletedAt)) > time.Duration(cfg.TrashMaxAgeDays)*24*time.Hour && !trashRestoring[item.ID] {
+			_ = removeTrashItem(item)
+			continue
+		}
+		kept = append(kept, item)
+	}
+	evictTrash(kept, func(*trashItem) bool { return true })
+}
+
+func listTrash(c echo.Context) error {
+	u := middlewares.CurrentUser(c)
+	list := []*trashItem{}
+	for _, item := range readTrashItems() {
+		if u.Admin || item.Owner == u.Username {
+			list = append(list, item)
+		}
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+// restoreTarget applies the conflict policy when the original path is taken:
+// "rename" picks "name (n).ext", "overwrite" replaces it, "skip" leaves the item in the trash.
+// Whatever "overwrite" replaces goes to owner's trash first, so it can be
+// restored in turn. Callers must not hold trashMu.
+func restoreTarget(p, conflict string, owner config.User) (string, error) {
+	if !isEntryExist(p) {
+		return p, nil
+	}
+	switch conflict {
+	case "overwrite":
+		return p, moveToTrash(owner, p)
+	case "skip":
+		return "", nil
+	case "", "rename":
+		return availableName(p), nil
+	}
+	return "", fmtError("Unknown conflict policy: %s", conflict)
+}
+
+func availableName(p string) string {
+	dir, base := filepath.Split(p)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+	// Keep compound extensions such as ".tar.gz" together.
+	if e := filepath.Ext(stem); strings.EqualFold(e, ".tar") {
+		ext = e + ext
+		stem = strings.TrimSuffix(stem, e)
+	}
+	for i := 1; ; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if !isExist(candidate) {
+			return candidate
+		}
+	}
+}
+
+func restoreTrash(c echo.Context) error {
+	var body struct {
+		IDs      []string `json:"ids"`
+		Conflict string   `json:"conflict"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	u := middlewares.CurrentUser(c)
+	type result struct {
+		ID      string `json:"id"`
+		Path    string `json:"path,omitempty"`
+		Skipped bool   `json:"skipped,omitempty"`
+		Error   string `json:"error,omitempty"`
+	}
+	results := []result{}
+	for _, id := range body.IDs {
+		path, skipped, err := restoreTrashItem(u, id, body.Conflict)
+		switch {
+		case err != nil:
+			results = append(results, result{ID: id, Error: err.Error()})
+		case skipped:
+			results = append(results, result{ID: id, Skipped: true})
+		default:
+			results = append(results, result{ID: id, Path: path})
+		}
+	}
+	return c.JSON(http.StatusOK, results)
+}
+
+// restoreTrashItem moves one item back. The item is marked as being restored
+// while the conflict policy runs, since "overwrite" trashes the entry in the
+// way and that may evict older items.
+func restoreTrashItem(u config.User, id, conflict string) (string, bool, error) {
+	trashMu.Lock()
+	item, err := readTrashItem(id)
+	if err != nil || !(u.Admin || item.Owner == u.Username) || trashRestoring[id] {
+		trashMu.Unlock()
+		return "", false, errors.New("Trash item not found")
+	}
+	trashRestoring[id] = true
+	trashMu.Unlock()
+	defer func() {
+		trashMu.Lock()
+		delete(trashRestoring, id)
+		trashMu.Unlock()
+	}()
+
+	if !isPathWithin(u.Root, item.OriginalPath) {
+		return "", false, errors.New("Path is not safe: " + item.OriginalPath)
+	}
+	target, err := restoreTarget(item.OriginalPath, conflict, u)
+	if err != nil {
+		return "", false, err
+	}
+	if target == "" {
+		return "", true, nil
+	}
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", false, err
+	}
+	if err := os.Rename(item.Location, target); err != nil {
+		return "", false, err
+	}
+	_ = os.Remove(trashInfoPath(item.ID))
+	return target, false, nil
+}
+
+func purgeTrash(c echo.Context) error {
+	var body struct {
+		IDs []string `json:"ids"`
+		All bool     `json:"all"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	u := middlewares.CurrentUser(c)
+	trashMu.Lock()
+	defer trashMu.Unlock()
+	var items []*trashItem
+	if body.All {
+		items = readTrashItems()
+	} else {
+		for _, id := range body.IDs {
+			if item, err := readTrashItem(id); err == nil {
+				items = append(items, item)
+			}
+		}
+	}
+	purged := []string{}
+	for _, item := range items {
+		if !(u.Admin || item.Owner == u.Username) {
+			continue
+		}
+		if err := removeTrashItem(item); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+		}
+		purged = append(purged, item.ID)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"purged": purged})
+}
@@ -0,0 +1,397 @@
+package routes
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+)
+
+// Resumable uploads follow the tus core protocol shape:
+// POST creates a session, HEAD reports the current offset,
+// PATCH appends bytes at Upload-Offset, DELETE aborts.
+const (
+	uploadSessionTTL          = 24 * time.Hour
+	uploadSessionCleanupEvery = time.Hour
+)
+
+var uploadIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type uploadSession struct {
+	ID        string `json:"id"`
+	Owner     string `json:"owner"`
+	Root      string `json:"root"`
+	Dest      string `json:"dest"`
+	Name      string `json:"name"`
+	Size      int64  `json:"size"`
+	CreatedAt int64  `json:"createdAt"`
+	UpdatedAt int64  `json:"updatedAt"`
+	// Checksum ("algo:hex") is verified once the last byte arrives.
+	Checksum         string `json:"checksum,omitempty"`
+	ChecksumMismatch string `json:"checksumMismatch,omitempty"`
+}
+
+var errChecksumMismatch = errors.New("Checksum mismatch")
+
+var uploadLocks sync.Map
+var uploadCleanupOnce sync.Once
+
+func registerUploads(g *echo.Group, m ...echo.MiddlewareFunc) {
+	g.POST("/uploads", func(c echo.Context) error { return createUploadSession(c) }, m...)
+	g.HEAD("/uploads/:id", func(c echo.Context) error { return getUploadSession(c) }, m...)
+	g.GET("/uploads/:id", func(c echo.Context) error { return getUploadSession(c) }, m...)
+	g.PATCH("/uploads/:id", func(c echo.Context) error { return patchUploadSession(c) }, m...)
+	g.DELETE("/uploads/:id", func(c echo.Context) error { return deleteUploadSession(c) }, m...)
+
+	uploadCleanupOnce.Do(func() {
+		go func() {
+			for {
+				cleanupUploadSessions(time.Now())
+				time.Sleep(uploadSessionCleanupEvery)
+			}
+		}()
+	})
+}
+
+func uploadSessionsDir() string {
+	return filepath.Join(config.DataBaseDir(), "upload-sessions")
+}
+
+func uploadMetaPath(id string) string { return filepath.Join(uploadSessionsDir(), id+".json") }
+func uploadPartPath(id string) string { return filepath.Join(uploadSessionsDir(), id+".part") }
+
+func newRandomID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func readUploadSession(id string) (*uploadSession, error) {
+	if !uploadIDRe.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	b, err := os.ReadFile(uploadMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	var s uploadSession
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// readOwnUploadSession hides sessions created by other users.
+func readOwnUploadSession(c echo.Context, id string) (*uploadSession, error) {
+	s, err := readUploadSession(id)
+	if err != nil {
+		return nil, err
+	}
+	if u := middlewares.CurrentUser(c); !u.Admin && s.Owner != u.Username {
+		return nil, os.ErrNotExist
+	}
+	return s, nil
+}
+
+func writeUploadSession(s *uploadSession) error {
+	b, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	tmp := uploadMetaPath(s.ID) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, uploadMetaPath(s.ID))
+}
+
+func removeUploadSession(id string) {
+	_ = os.Remove(uploadPartPath(id))
+	_ = os.Remove(uploadMetaPath(id))
+	uploadLocks.Delete(id)
+}
+
+func lockUpload(id string) (func(), bool) {
+	v, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	m := v.(*sync.Mutex)
+	if !m.TryLock() {
+		return nil, false
+	}
+	return m.Unlock, true
+}
+
+func uploadOffset(id string) (int64, error) {
+	st, err := os.Stat(uploadPartPath(id))
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func cleanupUploadSessions(now time.Time) {
+	entries, err := os.ReadDir(uploadSessionsDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if filepath.Ext(name) != ".json" {
+			continue
+		}
+		id := name[:len(name)-len(".json")]
+		s, err := readUploadSession(id)
+		if err != nil {
+			continue
+		}
+		if now.Sub(time.UnixMilli(s.UpdatedAt)) < uploadSessionTTL {
+			continue
+		}
+		unlock, ok := lockUpload(id)
+		if !ok {
+			continue
+		}
+		removeUploadSession(id)
+		unlock()
+	}
+}
+
+func createUploadSession(c echo.Context) error {
+	var body struct {
+		Path             string `json:"path"`
+		Filename         string `json:"filename"`
+		Size             int64  `json:"size"`
+		Checksum         string `json:"checksum"`
+		ChecksumMismatch string `json:"checksumMismatch"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if body.Size < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid size"})
+	}
+	policy := ""
+	if body.Checksum != "" {
+		if _, err := newUploadChecksum(body.Checksum); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+		var err error
+		if policy, err = checksumMismatchPolicy(body.ChecksumMismatch); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": err.Error()})
+		}
+	}
+	var dest string
+	if body.Path != "" {
+		if !isPathSafe(c, body.Path) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe: " + body.Path})
+		}
+		dest = filepath.Dir(body.Path)
+		if body.Filename == "" {
+			body.Filename = filepath.Base(body.Path)
+		}
+	} else {
+		dest = filepath.Join(config.DataBaseDir(), "uploads")
+	}
+	name, err := sanitizeUploadFilename(body.Filename)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid filename"})
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	if err := os.MkdirAll(uploadSessionsDir(), 0755); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	now := time.Now().UnixMilli()
+	user := middlewares.CurrentUser(c)
+	s := &uploadSession{ID: id, Owner: user.Username, Root: user.Root, Dest: dest, Name: name, Size: body.Size, CreatedAt: now, UpdatedAt: now, Checksum: body.Checksum, ChecksumMismatch: policy}
+	if err := os.WriteFile(uploadPartPath(id), nil, 0644); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	if err := writeUploadSession(s); err != nil {
+		removeUploadSession(id)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	if s.Size == 0 {
+		if res, err := finishUpload(s); err != nil {
+			return uploadFinishError(c, res, err)
+		}
+	}
+	c.Response().Header().Set("Location", c.Request().URL.Path+"/"+id)
+	return c.JSON(http.StatusCreated, map[string]any{"id": id, "offset": 0, "size": s.Size})
+}
+
+func getUploadSession(c echo.Context) error {
+	id := c.Param("id")
+	s, err := readOwnUploadSession(c, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Upload session not found"})
+	}
+	offset, err := uploadOffset(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Upload session not found"})
+	}
+	h := c.Response().Header()
+	h.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	h.Set("Upload-Length", strconv.FormatInt(s.Size, 10))
+	h.Set("Cache-Control", "no-store")
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(http.StatusOK)
+	}
+	return c.JSON(http.StatusOK, map[string]any{"id": id, "offset": offset, "size": s.Size})
+}
+
+func patchUploadSession(c echo.Context) error {
+	id := c.Param("id")
+	unlock, ok := lockUpload(id)
+	if !ok {
+		return c.JSON(http.StatusConflict, map[string]string{"message": "Upload session is busy"})
+	}
+	defer unlock()
+
+	s, err := readOwnUploadSession(c, id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Upload session not found"})
+	}
+	offset, err := strconv.ParseInt(c.Request().Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Invalid Upload-Offset"})
+	}
+	current, err := uploadOffset(id)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Upload session not found"})
+	}
+	if offset != current {
+		c.Response().Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		return c.JSON(http.StatusConflict, map[string]any{"message": "Offset mismatch", "offset": current})
+	}
+
+	out, err := os.OpenFile(uploadPartPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed"})
+	}
+	remaining := s.Size - current
+	n, copyErr := io.Copy(out, io.LimitReader(c.Request().Body, remaining+1))
+	if n > remaining {
+		// Drop the whole chunk so the offset stays where the client last saw it.
+		_ = out.Truncate(current)
+		_ = out.Close()
+		c.Response().Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]any{"message": "Chunk exceeds upload size", "offset": current})
+	}
+	if err := out.Close(); err != nil && copyErr == nil {
+		copyErr = err
+	}
+
+	s.UpdatedAt = time.Now().UnixMilli()
+	_ = writeUploadSession(s)
+	offset = current + n
+	c.Response().Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	if copyErr != nil {
+		// The bytes that did land stay in the part file; the client resumes from Upload-Offset.
+		return c.JSON(http.StatusInternalServerError, map[string]any{"message": "Upload interrupted", "offset": offset})
+	}
+	if offset == s.Size {
+		res, err := finishUpload(s)
+		if err != nil {
+			return uploadFinishError(c, res, err)
+		}
+		if res != nil {
+			return c.JSON(http.StatusOK, res)
+		}
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func deleteUploadSession(c echo.Context) error {
+	id := c.Param("id")
+	unlock, ok := lockUpload(id)
+	if !ok {
+		return c.JSON(http.StatusConflict, map[string]string{"message": "Upload session is busy"})
+	}
+	defer unlock()
+	if _, err := readOwnUploadSession(c, id); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Upload session not found"})
+	}
+	removeUploadSession(id)
+	return c.NoContent(http.StatusNoContent)
+}
+
+func uploadFinishError(c echo.Context, res *uploadChecksumResult, err error) error {
+	if errors.Is(err, errChecksumMismatch) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]any{"message": err.Error(), "expected": res.Expected, "actual": res.Actual, "checksumMismatch": true})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+}
+
+// finishUpload moves the assembled part file to its destination. The rename is
+// atomic when the data dir and the destination share a filesystem; otherwise the
+// data is copied next to the target first and renamed from there.
+// With a checksum it also returns the verification result; a mismatch under
+// the "reject" policy discards the upload with errChecksumMismatch.
+func finishUpload(s *uploadSession) (*uploadChecksumResult, error) {
+	target := filepath.Join(s.Dest, s.Name)
+	if !isPathWithin(s.Root, target) && s.Dest != filepath.Join(config.DataBaseDir(), "uploads") {
+		return nil, errors.New("Path is not safe: " + target)
+	}
+	part := uploadPartPath(s.ID)
+	var check *uploadChecksum
+	var res *uploadChecksumResult
+	if s.Checksum != "" {
+		var err error
+		if check, err = newUploadChecksum(s.Checksum); err != nil {
+			return nil, err
+		}
+		f, err := os.Open(part)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(check, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		res = check.result()
+		if res.Mismatch && s.ChecksumMismatch != "flag" {
+			removeUploadSession(s.ID)
+			return res, errChecksumMismatch
+		}
+	}
+	if err := os.MkdirAll(s.Dest, 0755); err != nil {
+		return nil, err
+	}
+	if err := snapshotVersion(target, s.Owner); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(part, target); err != nil {
+		tmp := filepath.Join(s.Dest, "."+s.Name+"."+s.ID+".tmp")
+		if err := copyFile(nil, part, tmp); err != nil {
+			_ = os.Remove(tmp)
+			return nil, err
+		}
+		if err := os.Rename(tmp, target); err != nil {
+			_ = os.Remove(tmp)
+			return nil, err
+		}
+	}
+	removeUploadSession(s.ID)
+	if check != nil {
+		check.remember(target)
+	}
+	return res, nil
+}
@@ -0,0 +1,162 @@
+package routes
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+const (
+	usageDefaultTopN  = 20
+	usageMaxTopN      = 200
+	usageDefaultDepth = 4
+	usageMaxDepth     = 12
+	usageConcurrency  = 8
+)
+
+// usageReport is the last analysis of a root, kept in DATA_BASE_DIR/usage so
+// it can be shown again without rescanning.
+type usageReport struct {
+	Root      string `json:"root"`
+	ScannedAt int64  `json:"scannedAt"`
+	ElapsedMs int64  `json:"elapsedMs"`
+	TopN      int    `json:"topN"`
+	Depth     int    `json:"depth"`
+	*utils.UsageScan
+}
+
+func registerUsage(g *echo.Group, read echo.MiddlewareFunc) {
+	g.GET("/usage", func(c echo.Context) error { return getUsage(c) }, read)
+	g.POST("/usage/scan", func(c echo.Context) error { return scanUsage(c) }, read)
+}
+
+func usageDir() string { return filepath.Join(config.DataBaseDir(), "usage") }
+
+func usageReportPath(root string) string {
+	sum := sha1.Sum([]byte(root))
+	return filepath.Join(usageDir(), hex.EncodeToString(sum[:])+".json")
+}
+
+func readUsageReport(root string) (*usageReport, error) {
+	b, err := os.ReadFile(usageReportPath(root))
+	if err != nil {
+		return nil, err
+	}
+	var r usageReport
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func writeUsageReport(r *usageReport) error {
+	if err := os.MkdirAll(usageDir(), 0755); err != nil {
+		return err
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	p := usageReportPath(r.Root)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// scanUsage starts an analyzer job for path. Progress is reported through the
+// job; its totals are taken from the previous report of the same root, if
+// any, so they are an estimate.
+func scanUsage(c echo.Context) error {
+	var body struct {
+		Path  string `json:"path"`
+		TopN  int    `json:"topN"`
+		Depth int    `json:"depth"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if !isPathSafe(c, body.Path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	root, err := filepath.Abs(body.Path)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	if st, err := os.Stat(root); err != nil || !st.IsDir() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a directory"})
+	}
+	topN, depth := body.TopN, body.Depth
+	if topN <= 0 {
+		topN = usageDefaultTopN
+	}
+	if topN > usageMaxTopN {
+		topN = usageMaxTopN
+	}
+	if depth <= 0 {
+		depth = usageDefaultDepth
+	}
+	if depth > usageMaxDepth {
+		depth = usageMaxDepth
+	}
+
+	j := jobs.submit(middlewares.CurrentUser(c), "usage", []string{root}, "", func(j *job) {
+		if prev, err := readUsageReport(root); err == nil && prev.Tree != nil {
+			j.addTotal(prev.Tree.Size, prev.Tree.Files)
+		}
+		start := time.Now()
+		scan, err := utils.ScanUsage(j.ctx, root, utils.UsageOptions{
+			TopN:        topN,
+			Depth:       depth,
+			Concurrency: usageConcurrency,
+			OnDir:       j.addDone,
+		})
+		if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				j.addError(root, err.Error())
+			}
+			return
+		}
+		r := &usageReport{
+			Root:      root,
+			ScannedAt: time.Now().UnixMilli(),
+			ElapsedMs: time.Since(start).Milliseconds(),
+			TopN:      topN,
+			Depth:     depth,
+			UsageScan: scan,
+		}
+		if err := writeUsageReport(r); err != nil {
+			j.addError(root, err.Error())
+		}
+	})
+	return c.JSON(http.StatusAccepted, map[string]string{"path": root, "jobId": j.status.ID})
+}
+
+func getUsage(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	root, err := filepath.Abs(path)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	r, err := readUsageReport(root)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "No usage report for this path"})
+	}
+	return c.JSON(http.StatusOK, r)
+}
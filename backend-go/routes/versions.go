@@ -0,0 +1,325 @@
+package routes
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+	"file-lite-go/utils"
+)
+
+// Before a write replaces an existing file its content is copied to
+// DATA_BASE_DIR/versions/<sha1 of the path>/<id>, next to <id>.json with the
+// metadata. Versions are kept per resolved path, so saving through a symlink
+// and through its target share one history.
+const versionPurgeEvery = time.Hour
+
+var versionIDRe = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+type fileVersion struct {
+	ID           string      `json:"id"`
+	Path         string      `json:"path"`
+	Size         int64       `json:"size"`
+	Mode         os.FileMode `json:"mode"`
+	LastModified int64       `json:"lastModified"`
+	CreatedAt    int64       `json:"createdAt"`
+	By           string      `json:"by"`
+}
+
+// versionLocks serializes snapshots and retention per version directory: a
+// directory is never removed while a snapshot is being written into it, and
+// writes to different files do not wait on each other.
+var versionLocks pathLocks
+var versionPurgeOnce sync.Once
+
+func registerVersions(g *echo.Group, read, upload echo.MiddlewareFunc) {
+	g.GET("/versions", func(c echo.Context) error { return listVersions(c) }, read)
+	g.GET("/versions/:id", func(c echo.Context) error { return getVersion(c) }, read)
+	g.POST("/versions/restore", func(c echo.Context) error { return restoreVersion(c) }, upload)
+
+	versionPurgeOnce.Do(func() {
+		go func() {
+			for {
+				enforceAllVersionLimits(time.Now())
+				time.Sleep(versionPurgeEvery)
+			}
+		}()
+	})
+}
+
+func versionsDir() string { return filepath.Join(config.DataBaseDir(), "versions") }
+
+// versionKey resolves p the way writes do, falling back to its resolved
+// parent directory when the file itself is gone.
+func versionKey(p string) string {
+	if r, err := filepath.EvalSymlinks(p); err == nil {
+		p = r
+	} else if dir, err := filepath.EvalSymlinks(filepath.Dir(p)); err == nil {
+		p = filepath.Join(dir, filepath.Base(p))
+	}
+	abs, err := filepath.Abs(p)
+	if err != nil {
+		return filepath.Clean(p)
+	}
+	return abs
+}
+
+func versionFileDir(key string) string {
+	sum := sha1.Sum([]byte(key))
+	return filepath.Join(versionsDir(), hex.EncodeToString(sum[:]))
+}
+
+func versionDataPath(key, id string) string { return filepath.Join(versionFileDir(key), id) }
+func versionInfoPath(key, id string) string { return filepath.Join(versionFileDir(key), id+".json") }
+
+// readVersions returns the versions kept in dir, newest first.
+func readVersions(dir string) []*fileVersion {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var list []*fileVersion
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !versionIDRe.MatchString(id) {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		var v fileVersion
+		if json.Unmarshal(b, &v) == nil {
+			list = append(list, &v)
+		}
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt > list[b].CreatedAt })
+	return list
+}
+
+func readVersion(key, id string) (*fileVersion, error) {
+	if !versionIDRe.MatchString(id) {
+		return nil, os.ErrNotExist
+	}
+	b, err := os.ReadFile(versionInfoPath(key, id))
+	if err != nil {
+		return nil, err
+	}
+	var v fileVersion
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+func removeVersion(dir, id string) {
+	_ = os.Remove(filepath.Join(dir, id+".json"))
+	_ = os.Remove(filepath.Join(dir, id))
+}
+
+// snapshotVersion keeps the current content of p before a write replaces it.
+// Missing files, non-regular files and files over versionMaxFileMB are not
+// versioned; neither is content identical to the newest version. An error
+// means the old content could not be kept and the write should not go ahead.
+func snapshotVersion(p, by string) error {
+	st, err := os.Stat(p)
+	if err != nil || !st.Mode().IsRegular() {
+		return nil
+	}
+	cfg := config.Config()
+	if cfg.VersionMaxFileMB > 0 && st.Size() > cfg.VersionMaxFileMB<<20 {
+		return nil
+	}
+	key := versionKey(p)
+	dir := versionFileDir(key)
+	unlock := versionLocks.lock(dir)
+	defer unlock()
+	if list := readVersions(dir); len(list) > 0 && list[0].Size == st.Size() && list[0].LastModified == st.ModTime().UnixMilli() {
+		return nil
+	}
+	id, err := newRandomID()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	src, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	_, err = utils.WriteFileAtomic(versionDataPath(key, id), src, 0600, nil, nil)
+	src.Close()
+	if err != nil {
+		return err
+	}
+	v := &fileVersion{
+		ID:           id,
+		Path:         key,
+		Size:         st.Size(),
+		Mode:         st.Mode().Perm(),
+		LastModified: st.ModTime().UnixMilli(),
+		CreatedAt:    time.Now().UnixMilli(),
+		By:           by,
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := versionInfoPath(key, id) + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		_ = os.Remove(versionDataPath(key, id))
+		return err
+	}
+	if err := os.Rename(tmp, versionInfoPath(key, id)); err != nil {
+		_ = os.Remove(tmp)
+		_ = os.Remove(versionDataPath(key, id))
+		return err
+	}
+	enforceVersionLimits(dir, time.Now())
+	return nil
+}
+
+// enforceVersionLimits drops the versions in dir that are older than
+// versionMaxAgeDays or beyond the newest versionMaxCount. Zero disables
+// either limit. Callers hold the directory's versionLocks entry.
+func enforceVersionLimits(dir string, now time.Time) {
+	cfg := config.Config()
+	list := readVersions(dir)
+	for i, v := range list {
+		tooOld := cfg.VersionMaxAgeDays > 0 && now.Sub(time.UnixMilli(v.CreatedAt)) > time.Duration(cfg.VersionMaxAgeDays)*24*time.Hour
+		if tooOld || (cfg.VersionMaxCount > 0 && i >= cfg.VersionMaxCount) {
+			removeVersion(dir, v.ID)
+		}
+	}
+	// Only succeeds once the last version is gone.
+	_ = os.Remove(dir)
+}
+
+func enforceAllVersionLimits(now time.Time) {
+	entries, err := os.ReadDir(versionsDir())
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		if e.IsDir() {
+			dir := filepath.Join(versionsDir(), e.Name())
+			unlock := versionLocks.lock(dir)
+			enforceVersionLimits(dir, now)
+			unlock()
+		}
+	}
+}
+
+func listVersions(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	list := readVersions(versionFileDir(versionKey(path)))
+	if list == nil {
+		list = []*fileVersion{}
+	}
+	return c.JSON(http.StatusOK, list)
+}
+
+func getVersion(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	key := versionKey(path)
+	v, err := readVersion(key, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Version not found"})
+	}
+	name := filepath.Base(v.Path)
+	h := c.Response().Header()
+	if c.QueryParam("inline") != "" {
+		h.Set("Content-Disposition", utils.InlineDisposition(name))
+	} else {
+		h.Set("Content-Disposition", utils.AttachmentDisposition(name))
+	}
+	if t := mime.TypeByExtension(filepath.Ext(name)); t != "" {
+		h.Set("Content-Type", t)
+	}
+	return c.File(versionDataPath(key, v.ID))
+}
+
+// restoreVersion writes a version back over the file, which is snapshotted
+// just before it is replaced so the restore can be undone. The file keeps its current mode, or
+// gets the version's mode if it no longer exists.
+func restoreVersion(c echo.Context) error {
+	var body struct {
+		Path string `json:"path"`
+		ID   string `json:"id"`
+	}
+	if err := c.Bind(&body); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Bad Request"})
+	}
+	if !isPathSafe(c, body.Path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	key := versionKey(body.Path)
+	if !isPathSafe(c, key) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	v, err := readVersion(key, body.ID)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Version not found"})
+	}
+	src, err := os.Open(versionDataPath(key, v.ID))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"message": "Version not found"})
+	}
+	defer src.Close()
+
+	unlock := lockSave(key)
+	defer unlock()
+	var orig os.FileInfo
+	perm := v.Mode.Perm()
+	if st, err := os.Stat(key); err == nil {
+		if !st.Mode().IsRegular() {
+			return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a file"})
+		}
+		orig, perm = st, st.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(key), 0755); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	var snapErr error
+	size, err := utils.WriteFileAtomic(key, src, perm, orig, func() error {
+		snapErr = snapshotVersion(key, middlewares.CurrentUser(c).Username)
+		return snapErr
+	})
+	if snapErr != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": "Failed to keep the current version: " + snapErr.Error()})
+	}
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	st, err := os.Stat(key)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	c.Response().Header().Set("ETag", fileETag(st))
+	return c.JSON(http.StatusOK, map[string]any{
+		"path":         body.Path,
+		"size":         size,
+		"etag":         fileETag(st),
+		"lastModified": st.ModTime().UnixMilli(),
+	})
+}
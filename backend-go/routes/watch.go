@@ -0,0 +1,141 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"file-lite-go/utils"
+)
+
+const watchHeartbeatInterval = 25 * time.Second
+
+// sharedWatcher fans the events of one DirWatcher out to every subscriber of
+// the same directory. It is closed when the last subscriber leaves.
+type sharedWatcher struct {
+	w    *utils.DirWatcher
+	subs map[chan utils.WatchEvent]struct{}
+}
+
+type watchHub struct {
+	mu       sync.Mutex
+	watchers map[string]*sharedWatcher
+}
+
+var watchers = &watchHub{watchers: map[string]*sharedWatcher{}}
+
+func (h *watchHub) subscribe(path string) (chan utils.WatchEvent, func(), error) {
+	key := filepath.Clean(path)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	sw := h.watchers[key]
+	if sw == nil {
+		w, err := utils.WatchDir(key)
+		if err != nil {
+			return nil, nil, err
+		}
+		sw = &sharedWatcher{w: w, subs: map[chan utils.WatchEvent]struct{}{}}
+		h.watchers[key] = sw
+		go h.dispatch(key, sw)
+	}
+	ch := make(chan utils.WatchEvent, 64)
+	sw.subs[ch] = struct{}{}
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			h.mu.Lock()
+			defer h.mu.Unlock()
+			if _, ok := sw.subs[ch]; !ok {
+				return
+			}
+			delete(sw.subs, ch)
+			close(ch)
+			if len(sw.subs) == 0 {
+				if h.watchers[key] == sw {
+					delete(h.watchers, key)
+				}
+				_ = sw.w.Close()
+			}
+		})
+	}
+	return ch, unsubscribe, nil
+}
+
+func (h *watchHub) dispatch(key string, sw *sharedWatcher) {
+	for ev := range sw.w.Events {
+		h.mu.Lock()
+		for ch := range sw.subs {
+			// A slow client drops events rather than stalling everyone else.
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+		h.mu.Unlock()
+	}
+	// The watcher stopped on its own (e.g. the directory was removed): release subscribers.
+	h.mu.Lock()
+	if h.watchers[key] == sw {
+		delete(h.watchers, key)
+	}
+	for ch := range sw.subs {
+		delete(sw.subs, ch)
+		close(ch)
+	}
+	h.mu.Unlock()
+}
+
+func watchDirectory(c echo.Context) error {
+	path := c.QueryParam("path")
+	if !isPathSafe(c, path) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not safe"})
+	}
+	st, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c.JSON(http.StatusNotFound, map[string]string{"message": "Path not found"})
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	if !st.IsDir() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"message": "Path is not a directory"})
+	}
+	events, unsubscribe, err := watchers.subscribe(path)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"message": err.Error()})
+	}
+	defer unsubscribe()
+
+	res := c.Response()
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprint(res, "event: ready\ndata: {}\n\n")
+	res.Flush()
+
+	heartbeat := time.NewTicker(watchHeartbeatInterval)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-heartbeat.C:
+			fmt.Fprint(res, ": ping\n\n")
+		case ev, ok := <-events:
+			if !ok {
+				return nil
+			}
+			b, _ := json.Marshal(ev)
+			fmt.Fprintf(res, "event: %s\ndata: %s\n\n", ev.Type, b)
+		}
+		res.Flush()
+	}
+}
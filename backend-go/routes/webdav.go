@@ -0,0 +1,142 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/net/webdav"
+
+	"file-lite-go/config"
+	"file-lite-go/middlewares"
+)
+
+var webdavMethods = []string{
+	http.MethodOptions, http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+var webdavPermissions = map[string]string{
+	http.MethodOptions: config.PermRead,
+	http.MethodGet:     config.PermRead,
+	http.MethodHead:    config.PermRead,
+	"PROPFIND":         config.PermRead,
+	http.MethodPut:     config.PermUpload,
+	"PROPPATCH":        config.PermUpload,
+	"MKCOL":            config.PermUpload,
+	"COPY":             config.PermUpload,
+	"LOCK":             config.PermUpload, // creates a missing resource as an empty file
+	"UNLOCK":           config.PermUpload,
+	"MOVE":             config.PermRename,
+	http.MethodDelete:  config.PermDelete,
+}
+
+// webdavUserKey carries the username into sandboxFS through the request context.
+type webdavUserKey struct{}
+
+// sandboxFS is webdav.Dir with every name checked against the symlink policy,
+// since webdav.Dir itself follows symlinks wherever they lead.
+type sandboxFS struct {
+	webdav.Dir
+}
+
+func (fs sandboxFS) real(name string) string {
+	return filepath.Join(string(fs.Dir), filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (fs sandboxFS) within(name string) bool { return isPathWithin(string(fs.Dir), fs.real(name)) }
+
+func (fs sandboxFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	if !fs.within(name) {
+		return os.ErrPermission
+	}
+	return fs.Dir.Mkdir(ctx, name, perm)
+}
+
+// OpenFile keeps a version of a file that PUT is about to truncate.
+func (fs sandboxFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if !fs.within(name) {
+		return nil, os.ErrPermission
+	}
+	if flag&os.O_TRUNC != 0 {
+		by, _ := ctx.Value(webdavUserKey{}).(string)
+		if err := snapshotVersion(fs.real(name), by); err != nil {
+			return nil, err
+		}
+	}
+	return fs.Dir.OpenFile(ctx, name, flag, perm)
+}
+
+func (fs sandboxFS) RemoveAll(ctx context.Context, name string) error {
+	if !isEntryWithin(string(fs.Dir), fs.real(name)) {
+		return os.ErrPermission
+	}
+	return fs.Dir.RemoveAll(ctx, name)
+}
+
+func (fs sandboxFS) Rename(ctx context.Context, oldName, newName string) error {
+	if !isEntryWithin(string(fs.Dir), fs.real(oldName)) || !fs.within(newName) {
+		return os.ErrPermission
+	}
+	return fs.Dir.Rename(ctx, oldName, newName)
+}
+
+func (fs sandboxFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	if !fs.within(name) {
+		return nil, os.ErrPermission
+	}
+	return fs.Dir.Stat(ctx, name)
+}
+
+// RegisterWebDAV mounts a WebDAV server on config.WebDAVPrefix, rooted at the
+// authenticated user's sandbox. sandboxFS resolves every request path inside
+// that root, so the sandbox holds for all methods including the Destination of
+// MOVE/COPY.
+func RegisterWebDAV(e *echo.Echo) {
+	prefix := config.WebDAVPrefix()
+	if prefix == "" {
+		return
+	}
+	var mu sync.Mutex
+	handlers := map[string]*webdav.Handler{}
+	handlerFor := func(root string) *webdav.Handler {
+		mu.Lock()
+		defer mu.Unlock()
+		if h, ok := handlers[root]; ok {
+			return h
+		}
+		dir := root
+		if dir == "" {
+			dir = "/"
+		}
+		h := &webdav.Handler{
+			Prefix:     prefix,
+			FileSystem: sandboxFS{webdav.Dir(dir)},
+			// Lock names are relative to the root, so each root needs its own.
+			LockSystem: webdav.NewMemLS(),
+			Logger: func(r *http.Request, err error) {
+				if err != nil && config.Config().EnableLog {
+					e.Logger.Errorf("webdav %s %s: %v", r.Method, r.URL.Path, err)
+				}
+			},
+		}
+		handlers[root] = h
+		return h
+	}
+	handler := func(c echo.Context) error {
+		u := middlewares.CurrentUser(c)
+		if perm := webdavPermissions[c.Request().Method]; !u.Permissions.Allows(perm) {
+			return c.JSON(http.StatusForbidden, map[string]string{"message": "Permission denied: " + perm})
+		}
+		r := c.Request()
+		r = r.WithContext(context.WithValue(r.Context(), webdavUserKey{}, u.Username))
+		handlerFor(u.Root).ServeHTTP(c.Response(), r)
+		return nil
+	}
+	e.Match(webdavMethods, prefix, handler, middlewares.BasicAuthMiddleware)
+	e.Match(webdavMethods, prefix+"/*", handler, middlewares.BasicAuthMiddleware)
+}
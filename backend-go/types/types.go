@@ -6,9 +6,20 @@ type Entry struct {
 	IsDirectory  bool    `json:"isDirectory"`
 	Hidden       bool    `json:"hidden"`
 	LastModified int64   `json:"lastModified"`
-	Birthtime    int64   `json:"birthtime"`
+	Birthtime    *int64  `json:"birthtime"`
 	Size         *int64  `json:"size"`
 	Error        *string `json:"error"`
+	IsSymlink    bool    `json:"isSymlink"`
+	LinkTarget   string  `json:"linkTarget,omitempty"`
+
+	// Only set when listing with detail=true.
+	Mode  string  `json:"mode,omitempty"`
+	UID   *uint32 `json:"uid,omitempty"`
+	GID   *uint32 `json:"gid,omitempty"`
+	Owner string  `json:"owner,omitempty"`
+	Group string  `json:"group,omitempty"`
+	Inode uint64  `json:"inode,omitempty"`
+	Nlink uint64  `json:"nlink,omitempty"`
 }
 
 type Drive struct {
@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+)
+
+var ErrUnsupportedArchive = errors.New("unsupported archive format")
+
+// ArchiveEntry is a directory or regular file inside an archive. Name is the
+// slash separated path as stored in the archive and has not been sanitized.
+type ArchiveEntry struct {
+	Name    string
+	IsDir   bool
+	Mode    fs.FileMode
+	Size    int64
+	ModTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+// Open returns the content of a file entry. For tar archives it is only valid
+// until the WalkArchive callback returns.
+func (e *ArchiveEntry) Open() (io.ReadCloser, error) { return e.open() }
+
+// ArchiveFormat detects the archive format from the file name: "zip", "tar",
+// "tar.gz", "tar.bz2", or "" when unsupported.
+func ArchiveFormat(name string) string {
+	n := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(n, ".zip"):
+		return "zip"
+	case strings.HasSuffix(n, ".tar"):
+		return "tar"
+	case strings.HasSuffix(n, ".tar.gz"), strings.HasSuffix(n, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(n, ".tar.bz2"), strings.HasSuffix(n, ".tbz2"), strings.HasSuffix(n, ".tbz"):
+		return "tar.bz2"
+	}
+	return ""
+}
+
+// ArchiveTotals returns the number of files and their declared uncompressed
+// size without extracting anything. Only zip archives have a central
+// directory to read this from; ok is false for the tar formats.
+func ArchiveTotals(path string) (files, bytes int64, ok bool, err error) {
+	if ArchiveFormat(path) != "zip" {
+		return 0, 0, false, nil
+	}
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if f.Mode().IsRegular() && !strings.HasSuffix(f.Name, "/") {
+			files++
+			bytes += int64(f.UncompressedSize64)
+		}
+	}
+	return files, bytes, true, nil
+}
+
+// WalkArchive calls fn for every directory and regular file in the archive, in
+// archive order. Symlinks, hard links and device entries are skipped. An error
+// returned by fn stops the walk and is returned.
+func WalkArchive(path string, fn func(e *ArchiveEntry) error) error {
+	switch format := ArchiveFormat(path); format {
+	case "zip":
+		return walkZip(path, fn)
+	case "tar", "tar.gz", "tar.bz2":
+		return walkTar(path, format, fn)
+	}
+	return ErrUnsupportedArchive
+}
+
+func walkZip(path string, fn func(e *ArchiveEntry) error) error {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		mode := f.Mode()
+		isDir := mode.IsDir() || strings.HasSuffix(f.Name, "/")
+		if !isDir && !mode.IsRegular() {
+			continue
+		}
+		e := &ArchiveEntry{Name: f.Name, IsDir: isDir, Mode: mode, Size: int64(f.UncompressedSize64), ModTime: f.Modified, open: f.Open}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(path, format string, fn func(e *ArchiveEntry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = bufio.NewReader(f)
+	switch format {
+	case "tar.gz":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	case "tar.bz2":
+		r = bzip2.NewReader(r)
+	}
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if h.Typeflag != tar.TypeDir && h.Typeflag != tar.TypeReg {
+			continue
+		}
+		e := &ArchiveEntry{
+			Name:    h.Name,
+			IsDir:   h.Typeflag == tar.TypeDir,
+			Mode:    h.FileInfo().Mode(),
+			Size:    h.Size,
+			ModTime: h.ModTime,
+			open:    func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// WriteFileAtomic replaces p with the contents of r so that readers see
+// either the old or the new file, never a partial one: the data goes to a
+// temp file in the same directory, is fsynced, gets perm and then takes p's
+// name. When orig is the FileInfo of the file being replaced its owner is
+// carried over where the OS allows it. beforeRename, if not nil, runs once
+// the new content is complete; an error from it leaves p untouched.
+func WriteFileAtomic(p string, r io.Reader, perm os.FileMode, orig os.FileInfo, beforeRename func() error) (int64, error) {
+	dir := filepath.Dir(p)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(p)+".*.tmp")
+	if err != nil {
+		return 0, err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	n, err := io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return n, err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return n, err
+	}
+	if orig != nil {
+		CopyOwner(tmpName, orig)
+	}
+	if beforeRename != nil {
+		if err := beforeRename(); err != nil {
+			return n, err
+		}
+	}
+	if err := os.Rename(tmpName, p); err != nil {
+		return n, err
+	}
+	syncDir(dir)
+	return n, nil
+}
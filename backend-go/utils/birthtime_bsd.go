@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd
+
+package utils
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func birthtime(_ string, st os.FileInfo) time.Time {
+	s, ok := st.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(int64(s.Birthtimespec.Sec), int64(s.Birthtimespec.Nsec))
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package utils
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// birthtime asks statx for the creation time, which Stat_t does not carry.
+// Older kernels and some filesystems do not record it.
+func birthtime(p string, st os.FileInfo) time.Time {
+	flags := unix.AT_STATX_DONT_SYNC
+	if st.Mode()&os.ModeSymlink != 0 {
+		flags |= unix.AT_SYMLINK_NOFOLLOW
+	}
+	var sx unix.Statx_t
+	if err := unix.Statx(unix.AT_FDCWD, p, flags, unix.STATX_BTIME, &sx); err != nil || sx.Mask&unix.STATX_BTIME == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sx.Btime.Sec, int64(sx.Btime.Nsec))
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+func birthtime(_ string, st os.FileInfo) time.Time {
+	a, ok := st.Sys().(*syscall.Win32FileAttributeData)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(0, a.CreationTime.Nanoseconds())
+}
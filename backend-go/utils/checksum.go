@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+var checksumAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New256(nil)
+		return h
+	},
+	"crc32": func() hash.Hash { return crc32.NewIEEE() },
+}
+
+// ChecksumAlgorithms returns the names Checksummer accepts, sorted.
+func ChecksumAlgorithms() []string {
+	names := make([]string, 0, len(checksumAlgorithms))
+	for name := range checksumAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Checksummer computes several digests in a single pass over the data.
+type Checksummer struct {
+	algos  []string
+	hashes []hash.Hash
+	w      io.Writer
+}
+
+func NewChecksummer(algos []string) (*Checksummer, error) {
+	cs := &Checksummer{}
+	writers := make([]io.Writer, 0, len(algos))
+	for _, a := range algos {
+		newHash, ok := checksumAlgorithms[strings.ToLower(a)]
+		if !ok {
+			return nil, fmt.Errorf("Unknown checksum algorithm: %s", a)
+		}
+		h := newHash()
+		cs.algos = append(cs.algos, strings.ToLower(a))
+		cs.hashes = append(cs.hashes, h)
+		writers = append(writers, h)
+	}
+	cs.w = io.MultiWriter(writers...)
+	return cs, nil
+}
+
+func (cs *Checksummer) Write(p []byte) (int, error) { return cs.w.Write(p) }
+
+// Sums returns the lower case hex digest of every algorithm.
+func (cs *Checksummer) Sums() map[string]string {
+	sums := make(map[string]string, len(cs.algos))
+	for i, a := range cs.algos {
+		sums[a] = hex.EncodeToString(cs.hashes[i].Sum(nil))
+	}
+	return sums
+}
+
+// ChecksumFile hashes p with algos, reading it once through a fixed buffer.
+// It stops with ctx.Err() when ctx is canceled.
+func ChecksumFile(ctx context.Context, p string, algos []string) (map[string]string, error) {
+	cs, err := NewChecksummer(algos)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := io.Copy(cs, &ctxReader{ctx: ctx, r: f}); err != nil {
+		return nil, err
+	}
+	return cs.Sums(), nil
+}
+
+// ParseChecksum splits "sha256:ab12..." into its algorithm and hex digest.
+func ParseChecksum(s string) (algo, sum string, err error) {
+	algo, sum, ok := strings.Cut(s, ":")
+	algo, sum = strings.ToLower(algo), strings.ToLower(sum)
+	if _, known := checksumAlgorithms[algo]; !ok || !known {
+		return "", "", fmt.Errorf("Invalid checksum, expected <algorithm>:<hex>: %s", s)
+	}
+	if _, err := hex.DecodeString(sum); err != nil || sum == "" {
+		return "", "", fmt.Errorf("Invalid checksum digest: %s", s)
+	}
+	return algo, sum, nil
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
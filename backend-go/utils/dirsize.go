@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DirUsage is what a directory tree holds. Dirs does not count the directory
+// itself, and Errors counts directories that could not be read.
+type DirUsage struct {
+	Bytes  int64 `json:"bytes"`
+	Files  int64 `json:"files"`
+	Dirs   int64 `json:"dirs"`
+	Errors int64 `json:"errors"`
+}
+
+// dirSizeCacheMax bounds the number of directories remembered; the cache is
+// simply dropped when it fills up.
+const dirSizeCacheMax = 200000
+
+// DirSizeCacheTTL is how long a cached directory is trusted at most, even
+// when its mtime is unchanged.
+const DirSizeCacheTTL = 10 * time.Minute
+
+// dirSizeEntry is what one directory holds directly, valid while its mtime is
+// unchanged and for at most DirSizeCacheTTL. Adding, removing or renaming an
+// entry bumps the mtime; rewriting a file in place does not, so such changes
+// show up once the entry expires, or right away with a refresh.
+type dirSizeEntry struct {
+	mtime   time.Time
+	readAt  time.Time
+	bytes   int64
+	files   int64
+	subdirs []string
+}
+
+var dirSizeCache = struct {
+	sync.Mutex
+	m map[string]*dirSizeEntry
+}{m: map[string]*dirSizeEntry{}}
+
+// DirSizer adds up directory trees, reading at most `concurrency` directories
+// at a time across all Size calls on it. Symlinks are counted as links and
+// never followed.
+type DirSizer struct {
+	sem      chan struct{}
+	useCache bool
+}
+
+func NewDirSizer(concurrency int, useCache bool) *DirSizer {
+	return &DirSizer{sem: make(chan struct{}, concurrency), useCache: useCache}
+}
+
+// Size walks p, which must be a directory. It stops early with ctx.Err() when
+// ctx is canceled.
+func (s *DirSizer) Size(ctx context.Context, p string) (DirUsage, error) {
+	var u DirUsage
+	var wg sync.WaitGroup
+	s.walk(ctx, p, &u, &wg)
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return u, err
+	}
+	return u, nil
+}
+
+func (s *DirSizer) walk(ctx context.Context, p string, u *DirUsage, wg *sync.WaitGroup) {
+	if ctx.Err() != nil {
+		return
+	}
+	e, err := s.read(p)
+	if err != nil {
+		atomic.AddInt64(&u.Errors, 1)
+		return
+	}
+	atomic.AddInt64(&u.Bytes, e.bytes)
+	atomic.AddInt64(&u.Files, e.files)
+	atomic.AddInt64(&u.Dirs, int64(len(e.subdirs)))
+	for _, name := range e.subdirs {
+		sub := filepath.Join(p, name)
+		select {
+		case s.sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-s.sem }()
+				s.walk(ctx, sub, u, wg)
+			}()
+		default:
+			// Every worker is busy; keep going on this goroutine.
+			s.walk(ctx, sub, u, wg)
+		}
+	}
+}
+
+func (s *DirSizer) read(p string) (*dirSizeEntry, error) {
+	st, err := os.Lstat(p)
+	if err != nil {
+		return nil, err
+	}
+	if s.useCache {
+		dirSizeCache.Lock()
+		e := dirSizeCache.m[p]
+		dirSizeCache.Unlock()
+		if e != nil && e.mtime.Equal(st.ModTime()) && time.Since(e.readAt) < DirSizeCacheTTL {
+			return e, nil
+		}
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		return nil, err
+	}
+	e := &dirSizeEntry{mtime: st.ModTime(), readAt: time.Now()}
+	for _, de := range entries {
+		if de.IsDir() {
+			e.subdirs = append(e.subdirs, de.Name())
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		e.bytes += info.Size()
+		e.files++
+	}
+	dirSizeCache.Lock()
+	if len(dirSizeCache.m) >= dirSizeCacheMax {
+		dirSizeCache.m = map[string]*dirSizeEntry{}
+	}
+	dirSizeCache.m[p] = e
+	dirSizeCache.Unlock()
+	return e, nil
+}
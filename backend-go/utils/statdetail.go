@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"os"
+	"os/user"
+	"sync"
+	"time"
+)
+
+// FileDetail is the metadata StatDetail reads on top of an os.FileInfo.
+// Fields the platform does not provide are left zero.
+type FileDetail struct {
+	UID   *uint32
+	GID   *uint32
+	Owner string
+	Group string
+	Inode uint64
+	Nlink uint64
+}
+
+// Birthtime returns the creation time of p, whose stat (or lstat, for a
+// symlink) result is st. It is zero when the OS or filesystem does not record
+// one; callers must not substitute the mtime.
+func Birthtime(p string, st os.FileInfo) time.Time { return birthtime(p, st) }
+
+// Name lookups go through NSS, which may mean a network round trip, so the
+// results are kept for the life of the process.
+var (
+	userNames  sync.Map
+	groupNames sync.Map
+)
+
+func lookupUserName(id string) string {
+	if v, ok := userNames.Load(id); ok {
+		return v.(string)
+	}
+	name := ""
+	if u, err := user.LookupId(id); err == nil {
+		name = u.Username
+	}
+	userNames.Store(id, name)
+	return name
+}
+
+func lookupGroupName(id string) string {
+	if v, ok := groupNames.Load(id); ok {
+		return v.(string)
+	}
+	name := ""
+	if g, err := user.LookupGroupId(id); err == nil {
+		name = g.Name
+	}
+	groupNames.Store(id, name)
+	return name
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// StatDetail returns ownership and inode for p, whose stat (or lstat, for a
+// symlink) result is st.
+func StatDetail(p string, st os.FileInfo) FileDetail {
+	var d FileDetail
+	if s, ok := st.Sys().(*syscall.Stat_t); ok {
+		uid, gid := uint32(s.Uid), uint32(s.Gid)
+		d.UID, d.GID = &uid, &gid
+		d.Owner = lookupUserName(strconv.FormatUint(uint64(uid), 10))
+		d.Group = lookupGroupName(strconv.FormatUint(uint64(gid), 10))
+		d.Inode = uint64(s.Ino)
+		d.Nlink = uint64(s.Nlink)
+	}
+	return d
+}
+
+// CopyOwner gives p the owner and group of st, as far as the process may.
+func CopyOwner(p string, st os.FileInfo) {
+	if s, ok := st.Sys().(*syscall.Stat_t); ok {
+		_ = os.Lchown(p, int(s.Uid), int(s.Gid))
+	}
+}
+
+// syncDir makes a rename inside dir durable.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		_ = d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package utils
+
+import (
+	"os"
+)
+
+// StatDetail returns nothing on Windows: there is no uid/gid, and the file
+// index and link count would need the file opened.
+func StatDetail(string, os.FileInfo) FileDetail { return FileDetail{} }
+
+// CopyOwner is a no-op: files on Windows have no uid/gid to carry over.
+func CopyOwner(string, os.FileInfo) {}
+
+// syncDir is a no-op: Windows cannot fsync a directory handle.
+func syncDir(string) {}
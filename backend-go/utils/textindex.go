@@ -0,0 +1,347 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/gob"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	minTokenLen = 2
+	maxTokenLen = 64
+	// sniffLen is how much of a file IsTextFile looks at.
+	sniffLen = 8 << 10
+)
+
+// IndexedDoc is one file in a TextIndex. Terms are the distinct tokens of the
+// file, kept so the postings can be dropped when the file changes.
+type IndexedDoc struct {
+	Path    string
+	ModTime int64
+	Size    int64
+	Terms   []string
+}
+
+// TextIndex is an in-memory inverted index from token to the files that
+// contain it. Postings are sorted by document id; ids only grow, so Put can
+// append. Documents and postings are saved together, so loading a large index
+// does not have to rebuild it.
+type TextIndex struct {
+	mu       sync.RWMutex
+	nextID   uint32
+	docs     map[uint32]*IndexedDoc
+	byPath   map[string]uint32
+	postings map[string][]uint32
+}
+
+type textIndexSnapshot struct {
+	NextID   uint32
+	Docs     map[uint32]*IndexedDoc
+	Postings map[string][]uint32
+}
+
+// QueryTerm is one token of a search. A prefix term matches every token that
+// starts with Text; any other term only matches the whole token.
+type QueryTerm struct {
+	Text   string
+	Prefix bool
+}
+
+func NewTextIndex() *TextIndex {
+	return &TextIndex{docs: map[uint32]*IndexedDoc{}, byPath: map[string]uint32{}, postings: map[string][]uint32{}}
+}
+
+// Tokenize splits text into lower-cased words of letters and digits. Han,
+// Hiragana, Katakana and Hangul characters are not separated by spaces, so each
+// of them is a token of its own.
+func Tokenize(text string, fn func(token string)) {
+	start := -1
+	flush := func(end int) {
+		if start < 0 {
+			return
+		}
+		if n := utf8.RuneCountInString(text[start:end]); n >= minTokenLen && n <= maxTokenLen {
+			fn(strings.ToLower(text[start:end]))
+		}
+		start = -1
+	}
+	for i, r := range text {
+		switch {
+		case isIdeograph(r):
+			flush(i)
+			fn(string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_':
+			if start < 0 {
+				start = i
+			}
+		default:
+			flush(i)
+		}
+	}
+	flush(len(text))
+}
+
+// ParseQuery tokenizes a search query like the indexed text. A word written
+// with a trailing "*" ("conf*") becomes a prefix term. Repeated terms are
+// dropped.
+func ParseQuery(q string) []QueryTerm {
+	var terms []QueryTerm
+	seen := map[QueryTerm]bool{}
+	for _, field := range strings.Fields(q) {
+		prefix := strings.HasSuffix(field, "*")
+		var tokens []string
+		Tokenize(field, func(t string) { tokens = append(tokens, t) })
+		for i, t := range tokens {
+			qt := QueryTerm{Text: t, Prefix: prefix && i == len(tokens)-1}
+			if !seen[qt] {
+				seen[qt] = true
+				terms = append(terms, qt)
+			}
+		}
+	}
+	return terms
+}
+
+func isIdeograph(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+}
+
+// IsTextFile sniffs the start of a file: text files have no NUL bytes and are valid UTF-8.
+func IsTextFile(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return false
+	}
+	buf = buf[:n]
+	if bytes.IndexByte(buf, 0) >= 0 {
+		return false
+	}
+	if n == sniffLen {
+		// The buffer may end in the middle of a multi-byte character.
+		for i := 0; i < utf8.UTFMax && len(buf) > 0 && !utf8.Valid(buf); i++ {
+			buf = buf[:len(buf)-1]
+		}
+	}
+	return utf8.Valid(buf)
+}
+
+// TokenizeFile returns the distinct tokens of a text file.
+func TokenizeFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	seen := map[string]struct{}{}
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64<<10), 1<<20)
+	for sc.Scan() {
+		Tokenize(sc.Text(), func(t string) { seen[t] = struct{}{} })
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	terms := make([]string, 0, len(seen))
+	for t := range seen {
+		terms = append(terms, t)
+	}
+	return terms, nil
+}
+
+// Lookup reports the stored modification time and size of path.
+func (x *TextIndex) Lookup(path string) (modTime, size int64, ok bool) {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	id, ok := x.byPath[path]
+	if !ok {
+		return 0, 0, false
+	}
+	d := x.docs[id]
+	return d.ModTime, d.Size, true
+}
+
+// Put adds doc, replacing any earlier version of the same path.
+func (x *TextIndex) Put(doc *IndexedDoc) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(doc.Path)
+	x.nextID++
+	id := x.nextID
+	x.docs[id] = doc
+	x.byPath[doc.Path] = id
+	for _, t := range doc.Terms {
+		x.postings[t] = append(x.postings[t], id)
+	}
+}
+
+func (x *TextIndex) Remove(path string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.remove(path)
+}
+
+func (x *TextIndex) remove(path string) {
+	id, ok := x.byPath[path]
+	if !ok {
+		return
+	}
+	for _, t := range x.docs[id].Terms {
+		ids := x.postings[t]
+		if i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id }); i < len(ids) && ids[i] == id {
+			ids = append(ids[:i], ids[i+1:]...)
+		}
+		if len(ids) == 0 {
+			delete(x.postings, t)
+		} else {
+			x.postings[t] = ids
+		}
+	}
+	delete(x.docs, id)
+	delete(x.byPath, path)
+}
+
+// Paths returns every indexed path.
+func (x *TextIndex) Paths() []string {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	paths := make([]string, 0, len(x.byPath))
+	for p := range x.byPath {
+		paths = append(paths, p)
+	}
+	return paths
+}
+
+func (x *TextIndex) Len() int {
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	return len(x.docs)
+}
+
+// Search returns the paths of the documents that contain every term.
+func (x *TextIndex) Search(terms []QueryTerm) []string {
+	if len(terms) == 0 {
+		return nil
+	}
+	x.mu.RLock()
+	defer x.mu.RUnlock()
+	lists := make([][]uint32, len(terms))
+	smallest := 0
+	for i, t := range terms {
+		lists[i] = x.matching(t)
+		if len(lists[i]) < len(lists[smallest]) {
+			smallest = i
+		}
+	}
+	var paths []string
+next:
+	for _, id := range lists[smallest] {
+		for _, ids := range lists {
+			if !containsID(ids, id) {
+				continue next
+			}
+		}
+		paths = append(paths, x.docs[id].Path)
+	}
+	return paths
+}
+
+// matching returns the sorted ids of the documents that contain t. Prefix
+// terms look at every token in the index, which is fine at the sizes a
+// single server indexes.
+func (x *TextIndex) matching(t QueryTerm) []uint32 {
+	if !t.Prefix {
+		return x.postings[t.Text]
+	}
+	seen := map[uint32]bool{}
+	var ids []uint32
+	for term, p := range x.postings {
+		if !strings.HasPrefix(term, t.Text) {
+			continue
+		}
+		for _, id := range p {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	return ids
+}
+
+func containsID(ids []uint32, id uint32) bool {
+	i := sort.Search(len(ids), func(i int) bool { return ids[i] >= id })
+	return i < len(ids) && ids[i] == id
+}
+
+func LoadTextIndex(file string) (*TextIndex, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var snap textIndexSnapshot
+	if err := gob.NewDecoder(bufio.NewReader(f)).Decode(&snap); err != nil {
+		return nil, err
+	}
+	x := NewTextIndex()
+	x.nextID = snap.NextID
+	for id, d := range snap.Docs {
+		x.docs[id] = d
+		x.byPath[d.Path] = id
+	}
+	if snap.Postings != nil {
+		x.postings = snap.Postings
+		return x, nil
+	}
+	// Indexes saved before the postings were persisted.
+	for id, d := range snap.Docs {
+		for _, t := range d.Terms {
+			x.postings[t] = append(x.postings[t], id)
+		}
+	}
+	for _, ids := range x.postings {
+		sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+	}
+	return x, nil
+}
+
+// Save writes the index to file atomically.
+func (x *TextIndex) Save(file string) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+"-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	w := bufio.NewWriter(tmp)
+	x.mu.RLock()
+	err = gob.NewEncoder(w).Encode(textIndexSnapshot{NextID: x.nextID, Docs: x.docs, Postings: x.postings})
+	x.mu.RUnlock()
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"os"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// ThumbnailMaxPixels guards against decompression bombs: a tiny file can
+// declare a huge canvas that would need gigabytes of memory to decode.
+const ThumbnailMaxPixels = 64 * 1024 * 1024
+
+var ErrImageTooLarge = errors.New("image is too large")
+
+var thumbnailExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true}
+
+func IsThumbnailable(name string) bool {
+	i := strings.LastIndexByte(name, '.')
+	return i >= 0 && thumbnailExts[strings.ToLower(name[i:])]
+}
+
+// Thumbnail decodes the image at path and writes a copy scaled to fit within
+// maxSize x maxSize (never upscaled). Opaque images are written as JPEG, images
+// with transparency as PNG. It returns the content type written.
+func Thumbnail(path string, maxSize int, w io.Writer) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", err
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > ThumbnailMaxPixels {
+		return "", ErrImageTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", err
+	}
+
+	b := src.Bounds()
+	width, height := b.Dx(), b.Dy()
+	if width > maxSize || height > maxSize {
+		if width >= height {
+			height = height * maxSize / width
+			width = maxSize
+		} else {
+			width = width * maxSize / height
+			height = maxSize
+		}
+		if width < 1 {
+			width = 1
+		}
+		if height < 1 {
+			height = 1
+		}
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if width == b.Dx() && height == b.Dy() {
+		draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	} else {
+		xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, b, xdraw.Src, nil)
+	}
+
+	if dst.Opaque() {
+		return "image/jpeg", jpeg.Encode(w, dst, &jpeg.Options{Quality: 80})
+	}
+	return "image/png", png.Encode(w, dst)
+}
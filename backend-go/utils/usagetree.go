@@ -0,0 +1,155 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// UsageNode is one node of a disk usage tree, shaped for treemap libraries:
+// a name, a size and children. Children beyond the top N of a directory are
+// folded into one node with Other set; directories below the depth limit
+// keep their totals but no children.
+type UsageNode struct {
+	Name        string       `json:"name"`
+	Size        int64        `json:"size"`
+	Files       int64        `json:"files"`
+	IsDirectory bool         `json:"isDirectory,omitempty"`
+	Other       int          `json:"other,omitempty"`
+	Children    []*UsageNode `json:"children,omitempty"`
+}
+
+type UsageOptions struct {
+	TopN        int
+	Depth       int
+	Concurrency int
+	// OnDir, when set, is called after each directory is read with what it
+	// holds directly, e.g. to report progress.
+	OnDir func(bytes, files int64)
+}
+
+// UsageScan is the outcome of ScanUsage. Unreadable directories are counted
+// and the first few listed; they do not stop the scan.
+type UsageScan struct {
+	Tree         *UsageNode `json:"tree"`
+	Errors       int64      `json:"errors"`
+	ErrorSamples []string   `json:"errorSamples,omitempty"`
+}
+
+const usageErrorSamples = 20
+
+type usageScanner struct {
+	opts UsageOptions
+	sem  chan struct{}
+
+	mu      sync.Mutex
+	errors  int64
+	sample  []string
+	rootErr error
+}
+
+// ScanUsage builds the usage tree of root, which must be a directory.
+// Symlinks are counted as links and never followed. It returns ctx.Err()
+// when canceled, and the read error when root itself cannot be read.
+func ScanUsage(ctx context.Context, root string, opts UsageOptions) (*UsageScan, error) {
+	s := &usageScanner{opts: opts, sem: make(chan struct{}, opts.Concurrency)}
+	tree := s.scan(ctx, root, filepath.Base(root), 0)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if s.rootErr != nil {
+		return nil, s.rootErr
+	}
+	return &UsageScan{Tree: tree, Errors: s.errors, ErrorSamples: s.sample}, nil
+}
+
+func (s *usageScanner) fail(p string, err error) {
+	s.mu.Lock()
+	s.errors++
+	if len(s.sample) < usageErrorSamples {
+		s.sample = append(s.sample, p+": "+err.Error())
+	}
+	s.mu.Unlock()
+}
+
+func (s *usageScanner) scan(ctx context.Context, p, name string, depth int) *UsageNode {
+	node := &UsageNode{Name: name, IsDirectory: true}
+	if ctx.Err() != nil {
+		return node
+	}
+	entries, err := os.ReadDir(p)
+	if err != nil {
+		if depth == 0 && len(entries) == 0 {
+			s.rootErr = err
+		}
+		s.fail(p, err)
+		// ReadDir still returns what it read before failing.
+	}
+	var direct, files int64
+	var subdirs []int
+	children := make([]*UsageNode, len(entries))
+	for i, e := range entries {
+		if e.IsDir() {
+			subdirs = append(subdirs, i)
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		children[i] = &UsageNode{Name: e.Name(), Size: info.Size(), Files: 1}
+		direct += info.Size()
+		files++
+	}
+	if s.opts.OnDir != nil {
+		s.opts.OnDir(direct, files)
+	}
+
+	var wg sync.WaitGroup
+	for _, i := range subdirs {
+		i := i
+		sub := filepath.Join(p, entries[i].Name())
+		select {
+		case s.sem <- struct{}{}:
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer func() { <-s.sem }()
+				children[i] = s.scan(ctx, sub, entries[i].Name(), depth+1)
+			}()
+		default:
+			children[i] = s.scan(ctx, sub, entries[i].Name(), depth+1)
+		}
+	}
+	wg.Wait()
+
+	kept := children[:0]
+	for _, c := range children {
+		if c != nil {
+			node.Size += c.Size
+			node.Files += c.Files
+			kept = append(kept, c)
+		}
+	}
+	if depth >= s.opts.Depth {
+		return node
+	}
+	node.Children = pruneUsage(kept, s.opts.TopN)
+	return node
+}
+
+// pruneUsage keeps the n largest children and folds the rest into one node.
+func pruneUsage(children []*UsageNode, n int) []*UsageNode {
+	sort.SliceStable(children, func(a, b int) bool { return children[a].Size > children[b].Size })
+	if len(children) <= n {
+		return children
+	}
+	other := &UsageNode{Name: "(other)", Other: len(children) - n}
+	for _, c := range children[n:] {
+		other.Size += c.Size
+		other.Files += c.Files
+	}
+	return append(children[:n:n], other)
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os"
+	"syscall"
+)
+
+// SameDevice reports whether a and b live on the same filesystem, i.e. whether
+// a rename between them can succeed.
+func SameDevice(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	da, ok1 := sa.Sys().(*syscall.Stat_t)
+	db, ok2 := sb.Sys().(*syscall.Stat_t)
+	return ok1 && ok2 && da.Dev == db.Dev
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SameDevice reports whether a and b live on the same volume, i.e. whether
+// a rename between them can succeed.
+func SameDevice(a, b string) bool {
+	aa, err := filepath.Abs(a)
+	if err != nil {
+		return false
+	}
+	bb, err := filepath.Abs(b)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(filepath.VolumeName(aa), filepath.VolumeName(bb))
+}
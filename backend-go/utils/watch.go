@@ -0,0 +1,26 @@
+package utils
+
+const (
+	WatchCreate = "create"
+	WatchModify = "modify"
+	WatchDelete = "delete"
+	WatchRename = "rename"
+)
+
+// WatchEvent describes a change to a direct child of a watched directory.
+// Name is empty when the watched directory itself was removed or moved away.
+type WatchEvent struct {
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	OldName string `json:"oldName,omitempty"`
+}
+
+// DirWatcher reports changes to the entries of a single directory (not recursive).
+// Events is closed once the watcher stops, either through Close or because the
+// directory went away.
+type DirWatcher struct {
+	Events <-chan WatchEvent
+	close  func() error
+}
+
+func (w *DirWatcher) Close() error { return w.close() }
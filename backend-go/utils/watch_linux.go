@@ -0,0 +1,104 @@
+//go:build linux
+
+package utils
+
+import (
+	"bytes"
+	"os"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_ATTRIB | unix.IN_CLOSE_WRITE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_ONLYDIR | unix.IN_EXCL_UNLINK
+
+func WatchDir(path string) (*DirWatcher, error) {
+	// A non-blocking fd lets os.File use the runtime poller, so Close unblocks a pending Read.
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, path, inotifyMask); err != nil {
+		unix.Close(fd)
+		return nil, &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	f := os.NewFile(uintptr(fd), "inotify")
+	events := make(chan WatchEvent, 64)
+	var once sync.Once
+	closeFn := func() error {
+		var err error
+		once.Do(func() { err = f.Close() })
+		return err
+	}
+	go readInotify(f, events)
+	return &DirWatcher{Events: events, close: closeFn}, nil
+}
+
+func readInotify(f *os.File, events chan<- WatchEvent) {
+	defer close(events)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return
+		}
+		// A rename inside the directory arrives as a MOVED_FROM/MOVED_TO pair sharing a cookie.
+		var movedFrom string
+		var movedCookie uint32
+		// MODIFY and CLOSE_WRITE for one write land in the same batch; report it once.
+		var last WatchEvent
+		emit := func(ev WatchEvent) {
+			if ev == last {
+				return
+			}
+			last = ev
+			events <- ev
+		}
+		flushMovedFrom := func() {
+			if movedFrom != "" {
+				emit(WatchEvent{Type: WatchDelete, Name: movedFrom})
+				movedFrom = ""
+			}
+		}
+		for off := 0; off+unix.SizeofInotifyEvent <= n; {
+			raw := (*unix.InotifyEvent)(unsafe.Pointer(&buf[off]))
+			nameBytes := buf[off+unix.SizeofInotifyEvent : off+unix.SizeofInotifyEvent+int(raw.Len)]
+			name := string(bytes.TrimRight(nameBytes, "\x00"))
+			off += unix.SizeofInotifyEvent + int(raw.Len)
+
+			mask := raw.Mask
+			switch {
+			case mask&unix.IN_MOVED_FROM != 0:
+				flushMovedFrom()
+				movedFrom, movedCookie = name, raw.Cookie
+			case mask&unix.IN_MOVED_TO != 0:
+				if movedFrom != "" && movedCookie == raw.Cookie {
+					emit(WatchEvent{Type: WatchRename, Name: name, OldName: movedFrom})
+					movedFrom = ""
+				} else {
+					flushMovedFrom()
+					emit(WatchEvent{Type: WatchCreate, Name: name})
+				}
+			case mask&unix.IN_CREATE != 0:
+				flushMovedFrom()
+				emit(WatchEvent{Type: WatchCreate, Name: name})
+			case mask&unix.IN_DELETE != 0:
+				flushMovedFrom()
+				emit(WatchEvent{Type: WatchDelete, Name: name})
+			case mask&(unix.IN_MODIFY|unix.IN_ATTRIB|unix.IN_CLOSE_WRITE) != 0:
+				flushMovedFrom()
+				if name != "" {
+					emit(WatchEvent{Type: WatchModify, Name: name})
+				}
+			case mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0:
+				flushMovedFrom()
+				emit(WatchEvent{Type: WatchDelete})
+				_ = f.Close()
+				return
+			}
+		}
+		flushMovedFrom()
+	}
+}
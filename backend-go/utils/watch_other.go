@@ -0,0 +1,80 @@
+//go:build !linux
+
+package utils
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+const watchPollInterval = 2 * time.Second
+
+type watchStamp struct {
+	modTime int64
+	size    int64
+}
+
+// WatchDir falls back to polling the directory listing where inotify is not available.
+// Renames cannot be told apart from a delete plus a create here.
+func WatchDir(path string) (*DirWatcher, error) {
+	prev, err := snapshotDir(path)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan WatchEvent, 64)
+	done := make(chan struct{})
+	var once sync.Once
+	closeFn := func() error {
+		once.Do(func() { close(done) })
+		return nil
+	}
+	go func() {
+		defer close(events)
+		t := time.NewTicker(watchPollInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+			}
+			cur, err := snapshotDir(path)
+			if err != nil {
+				events <- WatchEvent{Type: WatchDelete}
+				return
+			}
+			for name, st := range cur {
+				old, ok := prev[name]
+				if !ok {
+					events <- WatchEvent{Type: WatchCreate, Name: name}
+				} else if old != st {
+					events <- WatchEvent{Type: WatchModify, Name: name}
+				}
+			}
+			for name := range prev {
+				if _, ok := cur[name]; !ok {
+					events <- WatchEvent{Type: WatchDelete, Name: name}
+				}
+			}
+			prev = cur
+		}
+	}()
+	return &DirWatcher{Events: events, close: closeFn}, nil
+}
+
+func snapshotDir(path string) (map[string]watchStamp, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]watchStamp, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		m[e.Name()] = watchStamp{modTime: info.ModTime().UnixNano(), size: info.Size()}
+	}
+	return m, nil
+}
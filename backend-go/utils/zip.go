@@ -1,54 +1,394 @@
 package utils
 
 import (
+	"archive/tar"
 	"archive/zip"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
+	"strings"
+	"time"
 )
 
-func addEmptyDir(z *zip.Writer, name string) error {
-	_, err := z.Create(name + "/")
+// ArchiveOptions controls WriteArchive.
+type ArchiveOptions struct {
+	// Level is a compress/flate level; flate.NoCompression stores all zip
+	// entries, otherwise only already compressed formats are stored.
+	Level int
+	// Exclude holds path.Match patterns tested against each entry's base name
+	// and its slash separated path inside the archive.
+	Exclude []string
+	// Skip, when set, drops source paths it returns true for.
+	Skip func(path string) bool
+	// Wrap, when set, wraps the reader of every file added, e.g. to report progress.
+	Wrap func(r io.Reader) io.Reader
+	// FollowLink, when set, decides whether zip output follows a symlink; links
+	// it rejects are skipped. Tar output always stores symlinks as links.
+	FollowLink func(path string) bool
+	// OnSkip, when set, is told about every path left out because it could not be read.
+	OnSkip func(path string, err error)
+	// ErrorManifest adds an ErrorManifestName entry listing the skipped paths, if any.
+	ErrorManifest bool
+}
+
+// ErrorManifestName is the entry WriteArchive adds for ArchiveOptions.ErrorManifest.
+const ErrorManifestName = "_errors.txt"
+
+// archiveWriter is the part that differs between zip and tar output.
+type archiveWriter interface {
+	addDir(name string, st os.FileInfo) error
+	addFile(name string, st os.FileInfo, r io.Reader) error
+	addLink(name string, st os.FileInfo, target string) error
+	// addBytes adds a generated regular file that has no counterpart on disk.
+	addBytes(name string, r io.Reader, size int64) error
+	Close() error
+}
+
+// storedExts are formats that are already compressed; deflating them again
+// costs CPU and saves next to nothing, so zip stores them as is.
+var storedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".heic": true,
+	".mp4": true, ".m4v": true, ".mkv": true, ".mov": true, ".avi": true, ".webm": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jar": true, ".apk": true, ".docx": true, ".xlsx": true, ".pptx": true, ".epub": true,
+}
+
+var errNotStored = errors.New("entry would be deflated")
+
+// zipArchiveWriter writes entries with their mtime and mode bits. archive/zip
+// switches to ZIP64 records by itself once an entry, the archive or the entry
+// count outgrows the classic format.
+type zipArchiveWriter struct {
+	z     *zip.Writer
+	level int
+	// storedOnly makes addFile fail with errNotStored instead of deflating.
+	storedOnly bool
+}
+
+func newZipArchiveWriter(w io.Writer, level int) *zipArchiveWriter {
+	z := zip.NewWriter(w)
+	if level != flate.DefaultCompression && level != flate.NoCompression {
+		z.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(out, level)
+		})
+	}
+	return &zipArchiveWriter{z: z, level: level}
+}
+
+func (a *zipArchiveWriter) method(name string) uint16 {
+	if a.level == flate.NoCompression || storedExts[strings.ToLower(path.Ext(name))] {
+		return zip.Store
+	}
+	return zip.Deflate
+}
+
+func (a *zipArchiveWriter) addDir(name string, st os.FileInfo) error {
+	fh, err := zip.FileInfoHeader(st)
+	if err != nil {
+		return err
+	}
+	fh.Name = name + "/"
+	_, err = a.z.CreateHeader(fh)
+	return err
+}
+
+func (a *zipArchiveWriter) addFile(name string, st os.FileInfo, r io.Reader) error {
+	fh, err := zip.FileInfoHeader(st)
+	if err != nil {
+		return err
+	}
+	fh.Name = name
+	fh.Method = a.method(name)
+	if a.storedOnly && fh.Method != zip.Store {
+		return errNotStored
+	}
+	w, err := a.z.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	// Copy no more than the size that was stat'ed, so ZipSize stays exact
+	// even if the file grows meanwhile.
+	_, err = io.Copy(w, io.LimitReader(r, st.Size()))
+	return err
+}
+
+// addBytes always stores, so the entry does not stop ZipSize from predicting the length.
+func (a *zipArchiveWriter) addBytes(name string, r io.Reader, size int64) error {
+	fh := &zip.FileHeader{Name: name, Method: zip.Store, Modified: time.Now()}
+	fh.SetMode(0644)
+	w, err := a.z.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	_, err = io.CopyN(w, r, size)
+	return err
+}
+
+// addLink is never called for zip: links are followed and archived as what they point to.
+func (a *zipArchiveWriter) addLink(name string, _ os.FileInfo, _ string) error {
+	return fmt.Errorf("%s: symlinks are not supported in zip archives", name)
+}
+
+func (a *zipArchiveWriter) Close() error { return a.z.Close() }
+
+// tarArchiveWriter writes a tar stream, gzip compressed when gz is set.
+type tarArchiveWriter struct {
+	tw *tar.Writer
+	gz *gzip.Writer
+}
+
+func newTarArchiveWriter(w io.Writer) *tarArchiveWriter {
+	return &tarArchiveWriter{tw: tar.NewWriter(w)}
+}
+
+func newTarGzArchiveWriter(w io.Writer, level int) (*tarArchiveWriter, error) {
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &tarArchiveWriter{tw: tar.NewWriter(gz), gz: gz}, nil
+}
+
+func (a *tarArchiveWriter) addDir(name string, st os.FileInfo) error {
+	h, err := tar.FileInfoHeader(st, "")
+	if err != nil {
+		return err
+	}
+	h.Name = name + "/"
+	return a.tw.WriteHeader(h)
+}
+
+func (a *tarArchiveWriter) addFile(name string, st os.FileInfo, r io.Reader) error {
+	h, err := tar.FileInfoHeader(st, "")
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	if err := a.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	// The header already promised st.Size() bytes; a file that shrank meanwhile
+	// cannot be represented, one that grew is cut off.
+	n, err := io.CopyN(a.tw, r, h.Size)
+	if err == io.EOF {
+		return fmt.Errorf("%s: file changed while archiving (%d of %d bytes)", name, n, h.Size)
+	}
+	return err
+}
+
+func (a *tarArchiveWriter) addLink(name string, st os.FileInfo, target string) error {
+	h, err := tar.FileInfoHeader(st, target)
+	if err != nil {
+		return err
+	}
+	h.Name = name
+	return a.tw.WriteHeader(h)
+}
+
+func (a *tarArchiveWriter) addBytes(name string, r io.Reader, size int64) error {
+	h := &tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: size, ModTime: time.Now()}
+	if err := a.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err := io.CopyN(a.tw, r, size)
 	return err
 }
 
-func zipPath(z *zip.Writer, base string, path string) error {
-	st, err := os.Stat(path)
+func (a *tarArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz == nil {
+		return nil
+	}
+	return a.gz.Close()
+}
+
+type archiveBuilder struct {
+	w    archiveWriter
+	opts ArchiveOptions
+	// preserve keeps symlinks as links, which only tar can represent; zip
+	// follows them. Both write every directory so its mode and mtime survive.
+	preserve bool
+	// sizeOnly replaces file contents with zeros of the same length.
+	sizeOnly bool
+	skipped  []string
+}
+
+func (b *archiveBuilder) skip(p string, err error) {
+	msg := err.Error()
+	var pe *fs.PathError
+	if errors.As(err, &pe) && pe.Path == p {
+		msg = pe.Op + ": " + pe.Err.Error()
+	}
+	b.skipped = append(b.skipped, p+": "+msg)
+	if b.opts.OnSkip != nil {
+		b.opts.OnSkip(p, err)
+	}
+}
+
+// finish writes the error manifest, if asked for, and closes the archive.
+func (b *archiveBuilder) finish() error {
+	if b.opts.ErrorManifest && len(b.skipped) > 0 {
+		manifest := "The following paths could not be read and are missing from this archive:\n\n" + strings.Join(b.skipped, "\n") + "\n"
+		var r io.Reader = strings.NewReader(manifest)
+		if b.sizeOnly {
+			r = zeroReader{}
+		}
+		if err := b.w.addBytes(ErrorManifestName, r, int64(len(manifest))); err != nil {
+			return err
+		}
+	}
+	return b.w.Close()
+}
+
+func (b *archiveBuilder) excluded(name string) bool {
+	for _, pattern := range b.opts.Exclude {
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *archiveBuilder) add(base string, p string) error {
+	if b.opts.Skip != nil && b.opts.Skip(p) {
+		return nil
+	}
+	st, err := os.Lstat(p)
+	if err == nil && !b.preserve && st.Mode()&os.ModeSymlink != 0 {
+		if b.opts.FollowLink != nil && !b.opts.FollowLink(p) {
+			b.skip(p, errors.New("symlink is not followed"))
+			return nil
+		}
+		st, err = os.Stat(p)
+	}
 	if err != nil {
+		b.skip(p, err)
 		return nil
 	}
-	name := filepath.Join(base, filepath.Base(path))
+	name := filepath.ToSlash(filepath.Join(base, filepath.Base(p)))
+	if b.excluded(name) {
+		return nil
+	}
+	if st.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(p)
+		if err != nil {
+			b.skip(p, err)
+			return nil
+		}
+		return b.w.addLink(name, st, target)
+	}
 	if st.IsDir() {
-		entries, _ := os.ReadDir(path)
-		if len(entries) == 0 {
-			return addEmptyDir(z, name)
+		entries, err := os.ReadDir(p)
+		if err != nil {
+			// ReadDir still returns what it read before failing.
+			b.skip(p, err)
+		}
+		if err := b.w.addDir(name, st); err != nil {
+			return err
 		}
 		for _, e := range entries {
-			if err := zipPath(z, name, filepath.Join(path, e.Name())); err != nil {
+			if err := b.add(name, filepath.Join(p, e.Name())); err != nil {
 				return err
 			}
 		}
 		return nil
 	}
-	f, err := os.Open(path)
+	if !st.Mode().IsRegular() {
+		b.skip(p, errors.New("not a regular file"))
+		return nil
+	}
+	f, err := os.Open(p)
 	if err != nil {
+		b.skip(p, err)
 		return nil
 	}
 	defer f.Close()
-	w, err := z.Create(filepath.ToSlash(name))
-	if err != nil {
-		return err
+	var r io.Reader = f
+	if b.sizeOnly {
+		r = io.LimitReader(zeroReader{}, st.Size())
+	} else if b.opts.Wrap != nil {
+		r = b.opts.Wrap(r)
 	}
-	_, err = io.Copy(w, f)
-	return err
+	return b.w.addFile(name, st, r)
 }
 
-func ZipPathsToWriter(paths []string, w io.Writer) error {
-	z := zip.NewWriter(w)
+// WriteArchive writes paths, directories recursively, to w as a "zip", "tar"
+// or "tar.gz" archive. Entries that cannot be read are left out and reported
+// through opts.OnSkip and opts.ErrorManifest. The tar
+// formats keep symlinks, mode bits and mtimes; zip follows symlinks.
+func WriteArchive(format string, paths []string, w io.Writer, opts ArchiveOptions) error {
+	var aw archiveWriter
+	switch format {
+	case "zip":
+		aw = newZipArchiveWriter(w, opts.Level)
+	case "tar":
+		aw = newTarArchiveWriter(w)
+	case "tar.gz":
+		tw, err := newTarGzArchiveWriter(w, opts.Level)
+		if err != nil {
+			return err
+		}
+		aw = tw
+	default:
+		return ErrUnsupportedArchive
+	}
+	b := &archiveBuilder{w: aw, opts: opts, preserve: format != "zip"}
 	for _, p := range paths {
-		if err := zipPath(z, "", p); err != nil {
+		if err := b.add("", p); err != nil {
 			return err
 		}
 	}
-	return z.Close()
+	return b.finish()
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
+
+type countWriter struct{ n int64 }
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// ZipSize predicts the exact length of the zip WriteArchive would produce, by
+// writing it with zeros in place of file contents. That only works when every
+// entry is stored; ok is false as soon as one would be deflated.
+func ZipSize(paths []string, opts ArchiveOptions) (size int64, ok bool) {
+	cw := &countWriter{}
+	aw := newZipArchiveWriter(cw, opts.Level)
+	aw.storedOnly = true
+	opts.OnSkip = nil
+	b := &archiveBuilder{w: aw, opts: opts, sizeOnly: true}
+	for _, p := range paths {
+		if err := b.add("", p); err != nil {
+			return 0, false
+		}
+	}
+	if err := b.finish(); err != nil {
+		return 0, false
+	}
+	return cw.n, true
+}
+
+func ZipPathsToWriter(paths []string, w io.Writer) error {
+	return WriteArchive("zip", paths, w, ArchiveOptions{Level: flate.DefaultCompression})
 }
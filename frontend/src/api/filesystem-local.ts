@@ -73,7 +73,7 @@ export const fsLocal = {
           isDirectory,
           hidden: name.startsWith('.'),
           lastModified,
-          birthtime: 0,
+          birthtime: null,
           size,
           error: null,
         })
@@ -41,11 +41,44 @@ export const fsWebApi = {
   renameEntry(params: { fromPath: string, toPath: string }) {
     return service.post('/rename', params)
   },
-  copyPaste(params: { fromPaths: string[], toPath: string, isMove: boolean }) {
-    return service.post('/copy-paste', params)
+  async copyPaste(params: { fromPaths: string[], toPath: string, isMove: boolean }) {
+    const res: any = await service.post('/copy-paste', params)
+    return await fsWebApi.waitJob(res?.jobId)
   },
-  deleteEntry(params: { path: string[] }) {
-    return service.post('/delete', params)
+  async deleteEntry(params: { path: string[] }) {
+    const res: any = await service.post('/delete', params)
+    return await fsWebApi.waitJob(res?.jobId)
+  },
+  getJobs() {
+    return service.get('/jobs')
+  },
+  getJob(id: string) {
+    return service.get(`/jobs/${id}`)
+  },
+  cancelJob(id: string) {
+    return service.post(`/jobs/${id}/cancel`)
+  },
+  // 后台任务：轮询直到结束，失败时抛出第一个错误
+  async waitJob(id?: string, interval = 500) {
+    if (!id) {
+      return
+    }
+    while (true) {
+      const job: any = await fsWebApi.getJob(id)
+      if (job.status === 'queued' || job.status === 'running') {
+        await new Promise(resolve => setTimeout(resolve, interval))
+        continue
+      }
+      if (job.status === 'failed' && job.errors?.length) {
+        const { path, message } = job.errors[0]
+        window.$message.error(path ? `${message} (${path})` : message)
+        throw new Error(message)
+      }
+      if (job.status === 'partial') {
+        window.$message.warning(`Skipped ${job.errors.length} item(s)`)
+      }
+      return job
+    }
   },
   getDownloadUrl(paths: string[]) {
     if (paths.length === 1) {
@@ -4,7 +4,8 @@ export interface IEntry {
   isDirectory: boolean
   hidden: boolean
   lastModified: number
-  birthtime: number
+  // null when the OS or filesystem does not record a creation time
+  birthtime: number | null
   size: number | null
   error: string | null
 }
@@ -18,7 +18,7 @@ export function getTooltip(item: IEntry) {
     },
     {
       label: 'Created',
-      value: formatDate(item.birthtime, 'YYYY-MM-DD HH:mm:ss'),
+      value: item.birthtime === null ? null : formatDate(item.birthtime, 'YYYY-MM-DD HH:mm:ss'),
     },
     {
       label: 'Error',
@@ -47,11 +47,11 @@ export function lastModifiedDescSorter(a: IEntry, b: IEntry) {
 }
 
 export function birthTimeSorter(a: IEntry, b: IEntry) {
-  return a.birthtime - b.birthtime
+  return (a.birthtime ?? 0) - (b.birthtime ?? 0)
 }
 
 export function birthTimeDescSorter(a: IEntry, b: IEntry) {
-  return -(a.birthtime - b.birthtime)
+  return -((a.birthtime ?? 0) - (b.birthtime ?? 0))
 }
 
 export const sortMethodMap = {
@@ -2,6 +2,8 @@ import request from 'supertest'
 import { expect } from 'chai'
 import * as path from 'node:path'
 import * as fs from "node:fs";
+import * as zlib from 'node:zlib'
+import * as crypto from 'node:crypto'
 import { fileURLToPath } from 'url';
 import { dirname } from 'path';
 import type { IEntry } from "@frontend/types/server.ts";
@@ -16,6 +18,169 @@ const BASE_URL = `${testConfig.sslKey ? 'https' : 'http'}://${testConfig.host ||
 // 创建 Supertest 实例
 const api = request(BASE_URL)
 
+const crcTable = Array.from({ length: 256 }, (_, n) => {
+  let c = n
+  for (let k = 0; k < 8; k++) {
+    c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1
+  }
+  return c >>> 0
+})
+
+const crc32 = (buf: Buffer) => {
+  let c = 0xffffffff
+  for (const b of buf) {
+    c = crcTable[(c ^ b) & 0xff] ^ (c >>> 8)
+  }
+  return (c ^ 0xffffffff) >>> 0
+}
+
+interface ZipEntry {
+  name: string
+  data?: Buffer
+  // unix 权限与类型位，例如 0o100644、0o120777（符号链接）
+  mode?: number
+  mtime?: Date
+  // 只写入中央目录的声明大小，用于构造解压炸弹
+  declaredSize?: number
+}
+
+// 构造仅存储（不压缩）的 zip
+const makeZip = (entries: ZipEntry[]) => {
+  const locals: Buffer[] = []
+  const centrals: Buffer[] = []
+  let offset = 0
+  for (const e of entries) {
+    const name = Buffer.from(e.name, 'utf-8')
+    const data = e.data || Buffer.alloc(0)
+    const crc = crc32(data)
+    const t = e.mtime || new Date(2020, 0, 2, 3, 4, 6)
+    const time = (t.getHours() << 11) | (t.getMinutes() << 5) | (t.getSeconds() >> 1)
+    const date = ((t.getFullYear() - 1980) << 9) | ((t.getMonth() + 1) << 5) | t.getDate()
+    const local = Buffer.alloc(30)
+    local.writeUInt32LE(0x04034b50, 0)
+    local.writeUInt16LE(20, 4)
+    local.writeUInt16LE(0x800, 6)
+    local.writeUInt16LE(time, 10)
+    local.writeUInt16LE(date, 12)
+    local.writeUInt32LE(crc, 14)
+    local.writeUInt32LE(data.length, 18)
+    local.writeUInt32LE(data.length, 22)
+    local.writeUInt16LE(name.length, 26)
+    locals.push(local, name, data)
+
+    let extra = Buffer.alloc(0)
+    const central = Buffer.alloc(46)
+    central.writeUInt32LE(0x02014b50, 0)
+    central.writeUInt16LE((3 << 8) | 20, 4)
+    central.writeUInt16LE(e.declaredSize ? 45 : 20, 6)
+    central.writeUInt16LE(0x800, 8)
+    central.writeUInt16LE(time, 12)
+    central.writeUInt16LE(date, 14)
+    central.writeUInt32LE(crc, 16)
+    if (e.declaredSize) {
+      central.writeUInt32LE(0xffffffff, 20)
+      central.writeUInt32LE(0xffffffff, 24)
+      extra = Buffer.alloc(20)
+      extra.writeUInt16LE(0x0001, 0)
+      extra.writeUInt16LE(16, 2)
+      extra.writeBigUInt64LE(BigInt(e.declaredSize), 4)
+      extra.writeBigUInt64LE(BigInt(data.length), 12)
+    } else {
+      central.writeUInt32LE(data.length, 20)
+      central.writeUInt32LE(data.length, 24)
+    }
+    central.writeUInt16LE(name.length, 28)
+    central.writeUInt16LE(extra.length, 30)
+    central.writeUInt32LE(((e.mode ?? (e.name.endsWith('/') ? 0o40755 : 0o100644)) << 16) >>> 0, 38)
+    central.writeUInt32LE(offset, 42)
+    centrals.push(central, name, extra)
+    offset += local.length + name.length + data.length
+  }
+  const cd = Buffer.concat(centrals)
+  const end = Buffer.alloc(22)
+  end.writeUInt32LE(0x06054b50, 0)
+  // 超过 65535 个条目时只写低 16 位，读取时以中央目录为准
+  end.writeUInt16LE(entries.length & 0xffff, 8)
+  end.writeUInt16LE(entries.length & 0xffff, 10)
+  end.writeUInt32LE(cd.length, 12)
+  end.writeUInt32LE(offset, 16)
+  return Buffer.concat([...locals, cd, end])
+}
+
+interface ZipListing {
+  name: string
+  // unix 权限与类型位
+  mode: number
+  // 扩展时间戳中的修改时间（秒）
+  mtime?: number
+  size: number
+  offset: number
+}
+
+// 读取 zip 的中央目录
+const readZip = (zip: Buffer) => {
+  const end = zip.lastIndexOf(Buffer.from([0x50, 0x4b, 0x05, 0x06]))
+  let p = zip.readUInt32LE(end + 16)
+  const list: ZipListing[] = []
+  while (zip.readUInt32LE(p) === 0x02014b50) {
+    const nameLen = zip.readUInt16LE(p + 28)
+    const extraLen = zip.readUInt16LE(p + 30)
+    const commentLen = zip.readUInt16LE(p + 32)
+    const entry: ZipListing = {
+      name: zip.subarray(p + 46, p + 46 + nameLen).toString('utf-8'),
+      mode: zip.readUInt32LE(p + 38) >>> 16,
+      size: zip.readUInt32LE(p + 20),
+      offset: zip.readUInt32LE(p + 42),
+    }
+    const extra = zip.subarray(p + 46 + nameLen, p + 46 + nameLen + extraLen)
+    for (let i = 0; i + 4 <= extra.length;) {
+      const id = extra.readUInt16LE(i)
+      const size = extra.readUInt16LE(i + 2)
+      if (id === 0x5455 && extra[i + 4] & 1) {
+        entry.mtime = extra.readUInt32LE(i + 5)
+      }
+      i += 4 + size
+    }
+    list.push(entry)
+    p += 46 + nameLen + extraLen + commentLen
+  }
+  return list
+}
+
+// 读取仅存储的 zip 条目内容
+const readStoredEntry = (zip: Buffer, entry: ZipListing) => {
+  const p = entry.offset
+  const start = p + 30 + zip.readUInt16LE(p + 26) + zip.readUInt16LE(p + 28)
+  return zip.subarray(start, start + entry.size)
+}
+
+// 构造 RGB PNG；pixels 为空时只写文件头，用于声明超大画布
+const makePng = (width: number, height: number, pixels?: Buffer) => {
+  const chunk = (type: string, data: Buffer) => {
+    const len = Buffer.alloc(4)
+    len.writeUInt32BE(data.length)
+    const body = Buffer.concat([Buffer.from(type, 'latin1'), data])
+    const crc = Buffer.alloc(4)
+    crc.writeUInt32BE(crc32(body))
+    return Buffer.concat([len, body, crc])
+  }
+  const ihdr = Buffer.alloc(13)
+  ihdr.writeUInt32BE(width, 0)
+  ihdr.writeUInt32BE(height, 4)
+  ihdr[8] = 8
+  ihdr[9] = 2
+  const parts = [Buffer.from([0x89, 0x50, 0x4e, 0x47, 0x0d, 0x0a, 0x1a, 0x0a]), chunk('IHDR', ihdr)]
+  if (pixels) {
+    const rows = []
+    for (let y = 0; y < height; y++) {
+      rows.push(Buffer.from([0]), pixels.subarray(y * width * 3, (y + 1) * width * 3))
+    }
+    parts.push(chunk('IDAT', zlib.deflateSync(Buffer.concat(rows))))
+  }
+  parts.push(chunk('IEND', Buffer.alloc(0)))
+  return Buffer.concat(parts)
+}
+
 describe('鉴权', () => {
 
   it('无Authorization头', async () => {
@@ -189,6 +354,24 @@ describe('文件管理', () => {
     testUploadFile(testFolderName, testFilename, 'modified test file.')
   })
 
+  // 复制/移动/删除为后台任务，轮询直到完成
+  const waitJob = async (jobId: string) => {
+    expect(jobId).to.be.a('string')
+    while (true) {
+      const response = await api.get(`/api/files/jobs/${jobId}`)
+        .set('Authorization', testConfig.password)
+        .expect('Content-Type', /json/)
+        .expect(200)
+      if (response.body.status === 'queued' || response.body.status === 'running') {
+        await new Promise(resolve => setTimeout(resolve, 100))
+        continue
+      }
+      expect(response.body.status).to.equal('done')
+      expect(response.body.errors).to.be.an('array').that.is.empty
+      return response.body
+    }
+  }
+
   const testDelete = (folderName: string, filename: string) => {
     const targetPath = path.join(legalPath, folderName, filename)
     it(`删除文件/文件夹：${targetPath}`, async () => {
@@ -198,8 +381,9 @@ describe('文件管理', () => {
           path: [targetPath],
         })
         .expect('Content-Type', /json/)
-        .expect(200)
+        .expect(202)
       expect(response.body).to.be.an('object')
+      await waitJob(response.body.jobId)
 
       // 检测文件是否已删除
       const response2 = await api.get('/api/files/list')
@@ -268,8 +452,9 @@ describe('文件管理', () => {
           isMove,
         })
         .expect('Content-Type', /json/)
-        .expect(200)
+        .expect(202)
       expect(response.body).to.be.an('object')
+      await waitJob(response.body.jobId)
 
       // 检测目标路径是否存在
       const response2 = await api.get('/api/files/list')
@@ -308,4 +493,1162 @@ describe('文件管理', () => {
     testCopy(path.join(legalPath, testFolderName, 'b.txt'), path.join(legalPath), true)
     testDelete('', 'b.txt')
   })
+
+  describe('回收站', () => {
+    const filename = 'trash.txt'
+    const targetPath = path.join(legalPath, testFolderName, filename)
+
+    testCreateFolder(testFolderName)
+    testUploadFile(testFolderName, filename, 'trash me')
+    testDelete(testFolderName, filename)
+
+    it(`从回收站还原：${targetPath}`, async () => {
+      const response = await api.get('/api/files/trash')
+        .set('Authorization', testConfig.password)
+        .expect('Content-Type', /json/)
+        .expect(200)
+      const item = response.body.find((i: any) => i.originalPath === targetPath)
+      expect(item).to.not.be.undefined
+
+      const response2 = await api.post('/api/files/trash/restore')
+        .set('Authorization', testConfig.password)
+        .send({ ids: [item.id] })
+        .expect('Content-Type', /json/)
+        .expect(200)
+      expect(response2.body[0]).to.have.property('path').that.equals(targetPath)
+
+      const response3 = await api.get('/api/files/stream')
+        .set('Authorization', testConfig.password)
+        .query({ path: targetPath, t: Date.now() })
+        .expect(200)
+      expect(response3.text).to.equal('trash me')
+    })
+
+    testDelete(testFolderName, filename)
+
+    it('覆盖还原时原位置的目录移入回收站', async () => {
+      // 原位置被同名目录占用
+      fs.mkdirSync(targetPath)
+      fs.writeFileSync(path.join(targetPath, 'inner.txt'), 'keep me')
+      const list = await api.get('/api/files/trash')
+        .set('Authorization', testConfig.password)
+        .expect(200)
+      const item = list.body.find((i: any) => i.originalPath === targetPath && !i.isDirectory)
+      expect(item).to.not.be.undefined
+
+      const response = await api.post('/api/files/trash/restore')
+        .set('Authorization', testConfig.password)
+        .send({ ids: [item.id], conflict: 'overwrite' })
+        .expect(200)
+      expect(response.body[0]).to.have.property('path').that.equals(targetPath)
+      expect(fs.readFileSync(targetPath, 'utf-8')).to.equal('trash me')
+
+      const list2 = await api.get('/api/files/trash')
+        .set('Authorization', testConfig.password)
+        .expect(200)
+      const replaced = list2.body.find((i: any) => i.originalPath === targetPath && i.isDirectory)
+      expect(replaced).to.not.be.undefined
+      expect(fs.readFileSync(path.join(replaced.location, 'inner.txt'), 'utf-8')).to.equal('keep me')
+      await api.post('/api/files/trash/purge')
+        .set('Authorization', testConfig.password)
+        .send({ ids: [replaced.id] })
+        .expect(200)
+    })
+
+    testDelete(testFolderName, filename)
+    testDelete('', testFolderName)
+  })
+
+  describe('断点续传', () => {
+    const filename = 'resumable.txt'
+    const targetPath = path.join(legalPath, testFolderName, filename)
+    let uploadId = ''
+
+    testCreateFolder(testFolderName)
+
+    it(`创建上传会话并分片上传：${targetPath}`, async () => {
+      const response = await api.post('/api/files/uploads')
+        .set('Authorization', testConfig.password)
+        .send({ path: targetPath, filename, size: 11 })
+        .expect('Content-Type', /json/)
+        .expect(201)
+      expect(response.body).to.have.property('id').that.is.a('string')
+      expect(response.body).to.have.property('offset').that.equals(0)
+      uploadId = response.body.id
+
+      await api.patch(`/api/files/uploads/${uploadId}`)
+        .set('Authorization', testConfig.password)
+        .set('Upload-Offset', '0')
+        .set('Content-Type', 'application/offset+octet-stream')
+        .send(Buffer.from('hello'))
+        .expect(204)
+
+      const response2 = await api.head(`/api/files/uploads/${uploadId}`)
+        .set('Authorization', testConfig.password)
+        .expect(200)
+      expect(response2.headers['upload-offset']).to.equal('5')
+
+      // 偏移量不一致时拒绝写入
+      await api.patch(`/api/files/uploads/${uploadId}`)
+        .set('Authorization', testConfig.password)
+        .set('Upload-Offset', '0')
+        .set('Content-Type', 'application/offset+octet-stream')
+        .send(Buffer.from('hello'))
+        .expect(409)
+
+      await api.patch(`/api/files/uploads/${uploadId}`)
+        .set('Authorization', testConfig.password)
+        .set('Upload-Offset', '5')
+        .set('Content-Type', 'application/offset+octet-stream')
+        .send(Buffer.from(' world'))
+        .expect(204)
+
+      const response3 = await api.get('/api/files/stream')
+        .set('Authorization', testConfig.password)
+        .query({ path: targetPath, t: Date.now() })
+        .expect(200)
+      expect(response3.text).to.equal('hello world')
+    })
+
+    testDelete(testFolderName, filename)
+    testDelete('', testFolderName)
+  })
+
+  describe('校验和', () => {
+    const dir = path.join(legalPath, testFolderName)
+    const file = path.join(dir, 'abc.txt')
+    // 各算法对 "abc" 的标准摘要
+    const vectors = {
+      md5: '900150983cd24fb0d6963f7d28e17f72',
+      sha1: 'a9993e364706816aba3e25717850c26c9cd0d89d',
+      sha256: 'ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad',
+      sha512: 'ddaf35a193617abacc417349ae20413112e6fa4e89a97ea20a9eeee64b55d39a2192992a274fc1a836ba3c23a3feebbd454d4423643ce80e2a9ac94fa54ca49f',
+      blake2b: 'bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319',
+      crc32: '352441c2',
+    }
+
+    testCreateFolder(testFolderName)
+
+    const checksum = (algo?: string) => api.get('/api/files/checksum')
+      .set('Authorization', testConfig.password)
+      .query(algo ? { path: file, algo } : { path: file })
+      .expect('Content-Type', /json/)
+
+    it('各算法的摘要与标准值一致', async () => {
+      fs.writeFileSync(file, 'abc')
+      const response = await checksum(Object.keys(vectors).join(',')).expect(200)
+      expect(response.body).to.include({ path: file, size: 3 })
+      expect(response.body.checksums).to.deep.equal(vectors)
+
+      // 默认只计算 sha256
+      expect((await checksum().expect(200)).body.checksums).to.deep.equal({ sha256: vectors.sha256 })
+    })
+
+    it('未知算法返回 400', async () => {
+      await checksum('sha3').expect(400)
+      await checksum('sha256,nope').expect(400)
+    })
+
+    const upload = (name: string, query: Record<string, string>) => api.post('/api/files/upload-file')
+      .set('Authorization', testConfig.password)
+      .attach('file', Buffer.from('abc'), name)
+      .query({ path: path.join(dir, name), ...query })
+      .expect('Content-Type', /json/)
+
+    it('上传校验一致时写入文件', async () => {
+      const response = await upload('match.txt', { checksum: `sha256:${vectors.sha256}` }).expect(200)
+      expect(response.body).to.include({ checksumMismatch: false, actual: `sha256:${vectors.sha256}` })
+      expect(fs.readFileSync(path.join(dir, 'match.txt'), 'utf-8')).to.equal('abc')
+    })
+
+    it('上传校验不一致时 reject 丢弃文件', async () => {
+      const wrong = `sha256:${'0'.repeat(64)}`
+      for (const query of [{ checksum: wrong }, { checksum: wrong, checksumMismatch: 'reject' }]) {
+        const response = await upload('rejected.txt', query).expect(422)
+        expect(response.body).to.include({ checksumMismatch: true, expected: wrong, actual: `sha256:${vectors.sha256}` })
+        expect(fs.existsSync(path.join(dir, 'rejected.txt'))).to.equal(false)
+      }
+      // 不留下临时文件
+      expect(fs.readdirSync(dir).filter(name => name.endsWith('.tmp'))).to.be.empty
+    })
+
+    it('上传校验不一致时 flag 保留文件并标记', async () => {
+      const response = await upload('flagged.txt', { checksum: `md5:${vectors.sha1.slice(0, 32)}`, checksumMismatch: 'flag' }).expect(200)
+      expect(response.body).to.include({ checksumMismatch: true, actual: `md5:${vectors.md5}` })
+      expect(fs.readFileSync(path.join(dir, 'flagged.txt'), 'utf-8')).to.equal('abc')
+    })
+
+    it('上传时未知算法或策略返回 400', async () => {
+      await upload('bad.txt', { checksum: 'sha3:abc' }).expect(400)
+      await upload('bad.txt', { checksum: `sha256:${vectors.sha256}`, checksumMismatch: 'ignore' }).expect(400)
+      expect(fs.existsSync(path.join(dir, 'bad.txt'))).to.equal(false)
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('保存', () => {
+    const dir = path.join(legalPath, testFolderName)
+    const file = path.join(dir, 'edit.txt')
+
+    testCreateFolder(testFolderName)
+
+    const save = (content: string, headers: Record<string, string> = {}, query: Record<string, any> = {}) => {
+      const req = api.post('/api/files/save')
+        .set('Authorization', testConfig.password)
+        .set('Content-Type', 'text/plain')
+      for (const [k, v] of Object.entries(headers)) {
+        req.set(k, v)
+      }
+      return req.query({ path: file, ...query })
+        .send(content)
+        .expect('Content-Type', /json/)
+    }
+
+    it('新建返回 201，覆盖返回 200', async () => {
+      const created = await save('v1', { 'If-None-Match': '*' }).expect(201)
+      expect(created.body).to.include({ path: file, size: 2 })
+      expect(created.headers.etag).to.equal(created.body.etag)
+      await save('v2', { 'If-None-Match': '*' }).expect(412)
+
+      const saved = await save('v2', { 'If-Match': created.body.etag }).expect(200)
+      expect(saved.body.etag).to.not.equal(created.body.etag)
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v2')
+    })
+
+    it('If-Match 不一致时返回 412 且不修改文件', async () => {
+      const st = fs.statSync(file)
+      const response = await save('stale', { 'If-Match': '"0-0"' }).expect(412)
+      expect(response.body).to.include({ lastModified: Math.floor(st.mtimeMs) })
+      expect(response.body.etag).to.be.a('string')
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v2')
+
+      // 用 412 返回的 ETag 重试即可成功
+      await save('v3', { 'If-Match': response.body.etag }).expect(200)
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v3')
+    })
+
+    it('mtime 不一致时返回 412', async () => {
+      const mtime = Math.floor(fs.statSync(file).mtimeMs)
+      await save('stale', {}, { mtime: mtime - 1000 }).expect(412)
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v3')
+      await save('v4', {}, { mtime }).expect(200)
+    })
+
+    it('If-Unmodified-Since 早于修改时间时返回 412', async () => {
+      const mtime = new Date(Date.UTC(2022, 0, 2, 3, 4, 5))
+      fs.utimesSync(file, mtime, mtime)
+      await save('stale', { 'If-Unmodified-Since': new Date(mtime.getTime() - 1000).toUTCString() }).expect(412)
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v4')
+      await save('v5', { 'If-Unmodified-Since': mtime.toUTCString() }).expect(200)
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v5')
+    })
+
+    it('原子替换并保留文件权限', async () => {
+      fs.chmodSync(file, 0o640)
+      const before = fs.statSync(file)
+      await save('v6').expect(200)
+      const after = fs.statSync(file)
+      expect(after.mode & 0o777).to.equal(0o640)
+      // 新内容写入临时文件后重命名，而不是原地改写
+      expect(after.ino).to.not.equal(before.ino)
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('v6')
+      expect(fs.readdirSync(dir).filter(name => name.endsWith('.tmp'))).to.be.empty
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('历史版本', () => {
+    const dir = path.join(legalPath, testFolderName)
+    const file = path.join(dir, 'versioned.txt')
+    // DATA_BASE_DIR/versions/<解析后路径的 sha1>
+    const versionDir = () => path.join(legalPath, 'versions', crypto.createHash('sha1').update(fs.realpathSync(file)).digest('hex'))
+
+    testCreateFolder(testFolderName)
+
+    const save = (content: string) => api.post('/api/files/save')
+      .set('Authorization', testConfig.password)
+      .set('Content-Type', 'text/plain')
+      .query({ path: file })
+      .send(content)
+      .expect('Content-Type', /json/)
+    const versions = async () => (await api.get('/api/files/versions')
+      .set('Authorization', testConfig.password)
+      .query({ path: file })
+      .expect('Content-Type', /json/)
+      .expect(200)).body
+    const sizes = async () => (await versions()).map((v: any) => v.size)
+
+    it('覆盖前保存旧内容并按时间倒序列出', async () => {
+      await save('a').expect(201)
+      // 清理之前运行留下的版本
+      fs.rmSync(versionDir(), { recursive: true, force: true })
+      expect(await versions()).to.deep.equal([])
+
+      await save('bb').expect(200)
+      await save('ccc').expect(200)
+      const list = await versions()
+      expect(list.map((v: any) => v.size)).to.deep.equal([2, 1])
+      expect(list[0]).to.include({ path: fs.realpathSync(file) })
+      expect(list[0].createdAt).to.be.at.least(list[1].createdAt)
+
+      const response = await api.get(`/api/files/versions/${list[1].id}`)
+        .set('Authorization', testConfig.password)
+        .query({ path: file })
+        .expect(200)
+      expect(response.text).to.equal('a')
+
+      await api.get(`/api/files/versions/${'0'.repeat(32)}`)
+        .set('Authorization', testConfig.password)
+        .query({ path: file })
+        .expect(404)
+    })
+
+    it('恢复历史版本并保留当前内容', async () => {
+      const [, oldest] = await versions()
+      const response = await api.post('/api/files/versions/restore')
+        .set('Authorization', testConfig.password)
+        .send({ path: file, id: oldest.id })
+        .expect('Content-Type', /json/)
+        .expect(200)
+      expect(response.body).to.include({ path: file, size: 1 })
+      expect(fs.readFileSync(file, 'utf-8')).to.equal('a')
+      // 恢复前的内容成为最新版本
+      expect(await sizes()).to.deep.equal([3, 2, 1])
+    })
+
+    it('超过 versionMaxCount 的旧版本被清理', async () => {
+      expect(testConfig.versionMaxCount).to.equal(3)
+      await save('dddd').expect(200)
+      expect(await sizes()).to.deep.equal([1, 3, 2])
+    })
+
+    it('超过 versionMaxAgeDays 的版本被清理', async () => {
+      // 两个版本过期：只按数量清理时会留下三个
+      const aged = (await versions()).slice(1)
+      expect(aged.map((v: any) => v.size)).to.deep.equal([3, 2])
+      for (const { id } of aged) {
+        const info = path.join(versionDir(), `${id}.json`)
+        const v = JSON.parse(fs.readFileSync(info, 'utf-8'))
+        v.createdAt = Date.now() - (testConfig.versionMaxAgeDays + 1) * 24 * 3600 * 1000
+        fs.writeFileSync(info, JSON.stringify(v))
+      }
+
+      await save('eeeee').expect(200)
+      expect(await sizes()).to.deep.equal([4, 1])
+      for (const { id } of aged) {
+        expect(fs.existsSync(path.join(versionDir(), id))).to.equal(false)
+      }
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('搜索', () => {
+    const filename = 'Search-Target.txt'
+
+    testCreateFolder(testFolderName)
+    testUploadFile(testFolderName, filename, 'search me')
+
+    it(`递归搜索：${filename}`, async () => {
+      const response = await api.get('/api/files/search')
+        .set('Authorization', testConfig.password)
+        .query({ path: legalPath, q: 'search-target', type: 'file' })
+        .buffer(true)
+        .parse((res, callback) => {
+          let text = ''
+          res.on('data', (chunk) => { text += chunk })
+          res.on('end', () => callback(null, text))
+        })
+        .expect('Content-Type', /ndjson/)
+        .expect(200)
+      const lines = (response.body as string).trim().split('\n').map(line => JSON.parse(line))
+      const summary = lines.pop()
+      expect(summary).to.have.property('done').that.equals(true)
+      expect(lines.map(hit => hit.path)).to.include(path.join(legalPath, testFolderName, filename))
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('分享', () => {
+    const filename = 'shared.txt'
+    const targetPath = path.join(legalPath, testFolderName, filename)
+    const content = 'shared content'
+
+    const createShare = async (body: object) => {
+      const response = await api.post('/api/files/shares')
+        .set('Authorization', testConfig.password)
+        .send({ path: targetPath, ...body })
+        .expect('Content-Type', /json/)
+        .expect(201)
+      expect(response.body).to.have.property('id').that.is.a('string')
+      return response.body.id as string
+    }
+
+    const htmlName = 'shared.html'
+    const svgName = 'shared.svg'
+
+    testCreateFolder(testFolderName)
+    testUploadFile(testFolderName, filename, content)
+
+    it('上传 HTML 与 SVG 文件', async () => {
+      const files = {
+        [htmlName]: '<script>alert(1)</script>',
+        [svgName]: '<svg xmlns="http://www.w3.org/2000/svg" onload="alert(1)"/>',
+      }
+      for (const [name, body] of Object.entries(files)) {
+        await api.post('/api/files/upload-file')
+          .set('Authorization', testConfig.password)
+          .attach('file', Buffer.from(body), name)
+          .query({ path: path.join(legalPath, testFolderName, name) })
+          .expect(200)
+      }
+    })
+
+    it('下载次数限制', async () => {
+      const id = await createShare({ maxDownloads: 2 })
+      const response = await api.get(`/api/share/${id}/download`).expect(200)
+      expect(response.text).to.equal(content)
+      // HEAD 不计入下载次数
+      await api.head(`/api/share/${id}/download`).expect(200)
+      await api.get(`/api/share/${id}/download`).expect(200)
+      await api.get(`/api/share/${id}/download`).expect('Content-Type', /json/).expect(410)
+    })
+
+    it('不带会话的 Range 请求同样计入下载次数', async () => {
+      const id = await createShare({ maxDownloads: 1 })
+      const response = await api.get(`/api/share/${id}/download`)
+        .set('Range', 'bytes=1-')
+        .expect(206)
+      expect(response.text).to.equal(content.slice(1))
+      await api.get(`/api/share/${id}/download`).set('Range', 'bytes=1-').expect(410)
+      await api.get(`/api/share/${id}/download`).set('Range', 'bytes=0-0').expect(410)
+    })
+
+    it('同一下载会话只计一次', async () => {
+      const id = await createShare({ maxDownloads: 1 })
+      const first = await api.get(`/api/share/${id}/download`).expect(200)
+      const cookies = ([] as string[]).concat(first.headers['set-cookie'] || [])
+      const session = cookies.map(c => c.split(';')[0]).find(c => c.startsWith(`file_lite_share_${id}=`))
+      expect(session).to.be.a('string')
+      const response = await api.get(`/api/share/${id}/download`)
+        .set('Cookie', session!)
+        .set('Range', 'bytes=7-')
+        .expect(206)
+      expect(response.text).to.equal(content.slice(7))
+      await api.get(`/api/share/${id}/download`).set('Cookie', session!).expect(200)
+      // 篡改过的 cookie 无效
+      const forged = session!.slice(0, -1) + (session!.endsWith('0') ? '1' : '0')
+      await api.get(`/api/share/${id}/download`).set('Cookie', forged).expect(410)
+      await api.get(`/api/share/${id}/download`).expect(410)
+    })
+
+    it('安全响应头，标记语言不以内联方式返回', async () => {
+      const text = await api.get(`/api/share/${await createShare({})}/download?inline=1`).expect(200)
+      expect(text.headers['content-security-policy']).to.equal('sandbox')
+      expect(text.headers['x-content-type-options']).to.equal('nosniff')
+      expect(text.headers['content-disposition']).to.match(/^inline/)
+      for (const name of [htmlName, svgName]) {
+        const id = await createShare({ path: path.join(legalPath, testFolderName, name) })
+        const response = await api.get(`/api/share/${id}/download?inline=1`).expect(200)
+        expect(response.headers['content-security-policy']).to.equal('sandbox')
+        expect(response.headers['content-disposition']).to.match(/^attachment/)
+      }
+    })
+
+    it('密码', async () => {
+      const id = await createShare({ password: 'share-secret' })
+      const info = await api.get(`/api/share/${id}`)
+        .expect('Content-Type', /json/)
+        .expect(200)
+      expect(info.body).to.have.property('requiresPassword').that.equals(true)
+      expect(info.body).to.not.have.property('path')
+      await api.get(`/api/share/${id}/download`).expect(401)
+      await api.get(`/api/share/${id}/download`).set('X-Share-Password', 'wrong').expect(401)
+      // 密码不接受查询参数，只能放在请求头或表单里
+      await api.get(`/api/share/${id}/download?password=share-secret`).expect(401)
+      const form = await api.post(`/api/share/${id}/download`)
+        .set('Content-Type', 'application/x-www-form-urlencoded')
+        .send('password=share-secret')
+        .expect(200)
+      expect(form.text).to.equal(content)
+      const response = await api.get(`/api/share/${id}/download`)
+        .set('X-Share-Password', 'share-secret')
+        .expect(200)
+      expect(response.text).to.equal(content)
+    })
+
+    it('过期', async function () {
+      this.timeout(5000)
+      const id = await createShare({ expiresAt: Date.now() + 1000 })
+      await api.get(`/api/share/${id}/download`).expect(200)
+      await new Promise(resolve => setTimeout(resolve, 1200))
+      await api.get(`/api/share/${id}`).expect(404)
+      await api.get(`/api/share/${id}/download`).expect(404)
+    })
+
+    it('分享列表与删除', async () => {
+      const response = await api.get('/api/files/shares')
+        .set('Authorization', testConfig.password)
+        .expect('Content-Type', /json/)
+        .expect(200)
+      const ids = response.body.filter((s: any) => s.path === targetPath).map((s: any) => s.id as string)
+      expect(ids).to.not.be.empty
+      for (const id of ids) {
+        await api.delete(`/api/files/shares/${id}`)
+          .set('Authorization', testConfig.password)
+          .expect(204)
+        await api.get(`/api/share/${id}`).expect(404)
+      }
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('解压', () => {
+    const folder = path.join(legalPath, testFolderName)
+    const archive = path.join(folder, 'archive.zip')
+    const toDir = path.join(folder, 'out')
+
+    // 等待任务结束，不要求成功
+    const finishJob = async (jobId: string) => {
+      while (true) {
+        const response = await api.get(`/api/files/jobs/${jobId}`)
+          .set('Authorization', testConfig.password)
+          .expect(200)
+        if (response.body.status !== 'queued' && response.body.status !== 'running') {
+          return response.body
+        }
+        await new Promise(resolve => setTimeout(resolve, 100))
+      }
+    }
+
+    const extract = async (zip: Buffer, conflict?: string) => {
+      fs.writeFileSync(archive, zip)
+      return api.post('/api/files/extract')
+        .set('Authorization', testConfig.password)
+        .send({ path: archive, toPath: toDir, conflict })
+    }
+
+    testCreateFolder(testFolderName)
+
+    it('拒绝 ../ 条目，绝对路径落在目标目录内', async () => {
+      const outside = path.join(folder, 'slip.txt')
+      const response = await extract(makeZip([
+        { name: 'ok.txt', data: Buffer.from('ok') },
+        { name: '../slip.txt', data: Buffer.from('evil') },
+        { name: '/abs-slip.txt', data: Buffer.from('evil') },
+      ]))
+      if (response.status === 202) {
+        const job = await finishJob(response.body.jobId)
+        expect(job.errors.map((e: any) => e.path)).to.include('../slip.txt')
+        expect(fs.readFileSync(path.join(toDir, 'ok.txt'), 'utf-8')).to.equal('ok')
+        expect(fs.readFileSync(path.join(toDir, 'abs-slip.txt'), 'utf-8')).to.equal('evil')
+      } else {
+        // 归档读取库直接拒绝不安全的路径
+        expect(response.status).to.equal(400)
+      }
+      expect(fs.existsSync(outside)).to.equal(false)
+      expect(fs.existsSync('/abs-slip.txt')).to.equal(false)
+    })
+
+    it('跳过符号链接条目', async () => {
+      const response = await extract(makeZip([
+        { name: 'link', data: Buffer.from('/etc/passwd'), mode: 0o120777 },
+        { name: 'plain.txt', data: Buffer.from('plain') },
+      ]), 'overwrite')
+      const job = await finishJob(response.body.jobId)
+      expect(job.status).to.equal('done')
+      expect(fs.existsSync(path.join(toDir, 'link'))).to.equal(false)
+      expect(fs.readFileSync(path.join(toDir, 'plain.txt'), 'utf-8')).to.equal('plain')
+    })
+
+    it('覆盖时文件不会替换同名目录', async () => {
+      fs.mkdirSync(path.join(toDir, 'dir.txt', 'sub'), { recursive: true })
+      fs.writeFileSync(path.join(toDir, 'dir.txt', 'sub', 'keep.txt'), 'keep')
+      const response = await extract(makeZip([
+        { name: 'dir.txt', data: Buffer.from('file') },
+        { name: 'plain.txt', data: Buffer.from('new') },
+      ]), 'overwrite')
+      const job = await finishJob(response.body.jobId)
+      expect(job.errors.map((e: any) => e.path)).to.deep.equal(['dir.txt'])
+      expect(fs.readFileSync(path.join(toDir, 'dir.txt', 'sub', 'keep.txt'), 'utf-8')).to.equal('keep')
+      expect(fs.readFileSync(path.join(toDir, 'plain.txt'), 'utf-8')).to.equal('new')
+    })
+
+    it('条目过多时拒绝解压', async function () {
+      this.timeout(30000)
+      const entries = Array.from({ length: 100001 }, (_, i) => ({ name: `f${i}` }))
+      const response = await extract(makeZip(entries))
+      expect(response.status).to.equal(413)
+      expect(fs.existsSync(path.join(toDir, 'f0'))).to.equal(false)
+    })
+
+    it('解压后过大时拒绝解压', async () => {
+      const response = await extract(makeZip([
+        { name: 'bomb.bin', data: Buffer.from('tiny'), declaredSize: 17 * 1024 ** 3 },
+      ]))
+      expect(response.status).to.equal(413)
+      expect(fs.existsSync(path.join(toDir, 'bomb.bin'))).to.equal(false)
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('压缩', () => {
+    const folder = path.join(legalPath, testFolderName)
+    const source = path.join(folder, 'src')
+
+    testCreateFolder(testFolderName)
+
+    it('跳过无法读取的文件时仍生成压缩包，状态为 partial', async () => {
+      fs.mkdirSync(source, { recursive: true })
+      fs.writeFileSync(path.join(source, 'a.txt'), 'a')
+      // 指向不存在文件的链接无法读取
+      fs.symlinkSync(path.join(source, 'missing.txt'), path.join(source, 'broken.txt'))
+      const response = await api.post('/api/files/compress')
+        .set('Authorization', testConfig.password)
+        .send({ paths: [source], toPath: folder, name: 'partial.zip' })
+        .expect(202)
+      let job: any
+      while (true) {
+        job = (await api.get(`/api/files/jobs/${response.body.jobId}`)
+          .set('Authorization', testConfig.password)
+          .expect(200)).body
+        if (job.status !== 'queued' && job.status !== 'running') {
+          break
+        }
+        await new Promise(resolve => setTimeout(resolve, 100))
+      }
+      expect(job.status).to.equal('partial')
+      expect(job.errors.map((e: any) => e.path)).to.include(path.join(source, 'broken.txt'))
+      expect(fs.existsSync(response.body.path)).to.equal(true)
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('列表', () => {
+    const filename = 'birthtime.txt'
+    const targetPath = path.join(legalPath, testFolderName, filename)
+    const mtime = Date.UTC(2001, 0, 1)
+
+    testCreateFolder(testFolderName)
+    testUploadFile(testFolderName, filename, 'birthtime')
+
+    it('创建时间不以修改时间代替', async () => {
+      await api.post('/api/files/touch')
+        .set('Authorization', testConfig.password)
+        .send({ paths: [targetPath], mtime })
+        .expect(200)
+      for (const detail of [false, true]) {
+        const response = await api.get('/api/files/list')
+          .set('Authorization', testConfig.password)
+          .query({ path: path.join(legalPath, testFolderName), detail })
+          .expect(200)
+        const entry = response.body.find((e: IEntry) => e.name === filename)
+        expect(entry.lastModified).to.equal(mtime)
+        expect(entry).to.have.property('birthtime')
+        // 不支持时为 null，否则是文件真正的创建时间（晚于被改回的修改时间）
+        if (entry.birthtime !== null) {
+          expect(entry.birthtime).to.be.greaterThan(mtime)
+        }
+      }
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('目录大小', () => {
+    const dir = path.join(legalPath, testFolderName, 'sized')
+    const file = path.join(dir, 'sub', 'grow.txt')
+
+    testCreateFolder(testFolderName)
+
+    const dirSize = async (refresh: boolean) => (await api.get('/api/files/dir-size')
+      .set('Authorization', testConfig.password)
+      .query({ path: dir, refresh })
+      .expect('Content-Type', /json/)
+      .expect(200)).body
+
+    it('原地改写文件后 refresh=true 重新统计', async () => {
+      fs.mkdirSync(path.dirname(file), { recursive: true })
+      fs.writeFileSync(file, '1234')
+      const first = await dirSize(false)
+      expect(first).to.include({ bytes: 4, files: 1, dirs: 1 })
+      expect(first.children[0]).to.include({ name: 'sub', bytes: 4 })
+
+      // 原地追加不会改变目录的修改时间
+      fs.appendFileSync(file, '5678')
+      const refreshed = await dirSize(true)
+      expect(refreshed).to.include({ bytes: 8, files: 1 })
+      // 刷新后的结果写回缓存
+      expect(await dirSize(false)).to.include({ bytes: 8, files: 1 })
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('重复文件', () => {
+    const dir = path.join(legalPath, testFolderName, 'dups')
+    const [a, b, c] = ['a.txt', 'b.txt', 'c.txt'].map(name => path.join(dir, name))
+
+    testCreateFolder(testFolderName)
+
+    const scan = async () => {
+      const response = await api.post('/api/files/duplicates')
+        .set('Authorization', testConfig.password)
+        .send({ path: dir })
+        .expect('Content-Type', /json/)
+        .expect(202)
+      await waitJob(response.body.jobId)
+      return response.body.jobId as string
+    }
+    const groups = async (jobId: string) => {
+      const response = await api.get(`/api/files/duplicates/${jobId}`)
+        .set('Authorization', testConfig.password)
+        .buffer(true)
+        .expect(200)
+      return response.text.trim().split('\n').map(line => JSON.parse(line))
+    }
+    const remove = (jobId: string, paths: string[]) => api.post(`/api/files/duplicates/${jobId}/delete`)
+      .set('Authorization', testConfig.password)
+      .send({ paths, permanent: true })
+      .expect('Content-Type', /json/)
+
+    it('删除后从扫描结果中移除', async () => {
+      fs.mkdirSync(dir, { recursive: true })
+      for (const p of [a, b, c]) {
+        fs.writeFileSync(p, 'same content')
+      }
+      const jobId = await scan()
+      const [group, done] = await groups(jobId)
+      expect(group.paths).to.deep.equal([a, b, c])
+      expect(done).to.include({ done: true, groups: 1 })
+
+      await waitJob((await remove(jobId, [a]).expect(202)).body.jobId)
+      expect(fs.existsSync(a)).to.equal(false)
+      expect((await groups(jobId))[0].paths).to.deep.equal([b, c])
+      // 已删除的路径不再属于该次扫描
+      await remove(jobId, [a]).expect(400)
+
+      // 只剩一份的组不再返回
+      await waitJob((await remove(jobId, [b]).expect(202)).body.jobId)
+      expect(await groups(jobId)).to.deep.equal([{ done: true, status: 'done', groups: 0 }])
+      await remove(jobId, [c]).expect(400)
+      expect(fs.existsSync(c)).to.equal(true)
+    })
+
+    it('保留的副本在扫描后被修改时拒绝删除', async () => {
+      for (const p of [a, b]) {
+        fs.writeFileSync(p, 'same content')
+      }
+      fs.rmSync(c)
+      const jobId = await scan()
+      fs.writeFileSync(b, 'changed content')
+      await remove(jobId, [a]).expect(400)
+      expect(fs.existsSync(a)).to.equal(true)
+
+      // 要删除的副本被修改同样拒绝
+      await remove(jobId, [b]).expect(409)
+      expect(fs.existsSync(b)).to.equal(true)
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('打包下载', () => {
+    const folder = path.join(legalPath, testFolderName)
+    const dir = path.join(folder, 'pack')
+    const mtime = Date.UTC(2021, 4, 6, 7, 8, 10)
+
+    testCreateFolder(testFolderName)
+
+    const download = (p: string) => api.get('/api/files/download')
+      .set('Authorization', testConfig.password)
+      .query({ path: p, format: 'zip' })
+      .buffer(true)
+      .parse((res, callback) => {
+        const chunks: Buffer[] = []
+        res.on('data', (chunk: Buffer) => chunks.push(Buffer.from(chunk)))
+        res.on('end', () => callback(null, Buffer.concat(chunks)))
+      })
+      .expect(200)
+
+    it('目录条目保留修改时间与权限', async () => {
+      fs.mkdirSync(path.join(dir, 'sub'), { recursive: true })
+      fs.writeFileSync(path.join(dir, 'sub', 'a.txt'), 'a')
+      const paths = [path.join(dir, 'sub'), path.join(dir, 'sub', 'a.txt')]
+      await api.post('/api/files/chmod')
+        .set('Authorization', testConfig.password)
+        .send({ paths: [paths[0]], mode: '750' })
+        .expect(200)
+      await api.post('/api/files/chmod')
+        .set('Authorization', testConfig.password)
+        .send({ paths: [paths[1]], mode: '640' })
+        .expect(200)
+      await api.post('/api/files/touch')
+        .set('Authorization', testConfig.password)
+        .send({ paths, mtime })
+        .expect(200)
+
+      const list = readZip((await download(dir)).body)
+      const sub = list.find(e => e.name === 'pack/sub/')
+      expect(sub).to.not.be.undefined
+      expect(sub!.mode).to.equal(0o40750)
+      expect(sub!.mtime).to.equal(mtime / 1000)
+      const file = list.find(e => e.name === 'pack/sub/a.txt')
+      expect(file!.mode).to.equal(0o100640)
+      expect(file!.mtime).to.equal(mtime / 1000)
+      // 非空目录同样有目录条目
+      expect(list.map(e => e.name)).to.include('pack/')
+    })
+
+    it('无法读取的文件记录在 _errors.txt', async () => {
+      fs.symlinkSync(path.join(dir, 'missing.txt'), path.join(dir, 'broken.txt'))
+      const zip = (await download(dir)).body
+      const list = readZip(zip)
+      expect(list.map(e => e.name)).to.not.include('pack/broken.txt')
+      const manifest = list.find(e => e.name === '_errors.txt')
+      expect(manifest).to.not.be.undefined
+      expect(readStoredEntry(zip, manifest!).toString('utf-8')).to.include(path.join(dir, 'broken.txt'))
+    })
+
+    testDelete('', testFolderName)
+  })
+
+  describe('缩略图', () => {
+    const imagePath = path.join(legalPath, testFolderName, 'image.png')
+    const hugePath = path.join(legalPath, testFolderName, 'huge.png')
+    const cacheDir = path.join(legalPath, 'thumbnails')
+    const cached = () => fs.existsSync(cacheDir) ? fs.readdirSync(cacheDir).length : 0
+
+    testCreateFolder(testFolderName)
+
+    it('生成并缓存缩略图', async () => {
+      fs.writeFileSync(imagePath, makePng(64, 32, Buffer.alloc(64 * 32 * 3, 0x80)))
+      const before = cached()
+      const first = await api.get('/api/files/thumbnail')
+        .set('Authorization', testConfig.password)
+        .query({ path: imagePath, size: 16 })
+        .expect('Content-Type', /^image\//)
+        .expect(200)
+      const etag = first.headers['etag']
+      expect(etag).to.be.a('string')
+      expect(cached()).to.equal(before + 1)
+
+      // 命中缓存：不会再生成新文件，内容与 ETag 不变
+      const second = await api.get('/api/files/thumbnail')
+        .set('Authorization', testConfig.password)
+        .query({ path: imagePath, size: 16 })
+        .expect(200)
+      expect(second.headers['etag']).to.equal(etag)
+      expect(Buffer.from(second.body).equals(Buffer.from(first.body))).to.equal(true)
+      expect(cached()).to.equal(before + 1)
+
+      await api.get('/api/files/thumbnail')
+        .set('Authorization', testConfig.password)
+        .set('If-None-Match', etag)
+        .query({ path: imagePath, size: 16 })
+        .expect(304)
+    })
+
+    it('像素过多的图片返回 422', async () => {
+      // 文件很小，但声明了 10000×10000 的画布
+      fs.writeFileSync(hugePath, makePng(10000, 10000))
+      const response = await api.get('/api/files/thumbnail')
+        .set('Authorization', testConfig.password)
+        .query({ path: hugePath })
+        .expect('Content-Type', /json/)
+        .expect(422)
+      expect(response.body.message).to.match(/too large/)
+    })
+
+    it('无法解码的图片返回 422', async () => {
+      fs.writeFileSync(hugePath, 'not a png')
+      const response = await api.get('/api/files/thumbnail')
+        .set('Authorization', testConfig.password)
+        .query({ path: hugePath })
+        .expect('Content-Type', /json/)
+        .expect(422)
+      expect(response.body.message).to.match(/decode/)
+    })
+
+    testDelete('', testFolderName)
+  })
+})
+
+// 多用户与 WebDAV 需要一个只读用户：FILE_LITE_TEST_USER=用户名:密码，
+// 该用户须在 config.json 的 users 中声明，权限只有 read，root 不是 /。未设置时跳过。
+const testUserToken = process.env.FILE_LITE_TEST_USER || ''
+
+describe('多用户', () => {
+  let userRoot = ''
+
+  before(async function () {
+    if (!testUserToken) {
+      this.skip()
+    }
+    const response = await api.get('/api/files/drives')
+      .set('Authorization', testUserToken)
+      .expect('Content-Type', /json/)
+      .expect(200)
+    expect(response.body).to.be.an('array').with.lengthOf(1)
+    userRoot = response.body[0].path
+  })
+
+  it('只读用户的权限', async () => {
+    const response = await api.get('/api/files/auth')
+      .set('Authorization', testUserToken)
+      .expect('Content-Type', /json/)
+      .expect(200)
+    expect(response.body).to.have.property('admin').that.equals(false)
+    expect(response.body.permissions).to.include({ read: true, upload: false, delete: false })
+  })
+
+  it('可以访问自己的 root', async () => {
+    const response = await api.get('/api/files/list')
+      .set('Authorization', testUserToken)
+      .query({ path: userRoot })
+      .expect('Content-Type', /json/)
+      .expect(200)
+    expect(response.body).to.be.an('array')
+  })
+
+  it('不能访问 root 之外的路径', async () => {
+    await api.get('/api/files/list')
+      .set('Authorization', testUserToken)
+      .query({ path: path.dirname(userRoot) })
+      .expect('Content-Type', /json/)
+      .expect(400)
+    await api.get('/api/files/list')
+      .set('Authorization', testUserToken)
+      .query({ path: path.join(userRoot, '..', path.basename(userRoot) + '-other') })
+      .expect('Content-Type', /json/)
+      .expect(400)
+  })
+
+  it('没有 upload 权限时不能上传', async () => {
+    await api.post('/api/files/upload-file')
+      .set('Authorization', testUserToken)
+      .attach('file', Buffer.from('denied'), 'denied.txt')
+      .query({ path: path.join(userRoot, 'denied.txt') })
+      .expect('Content-Type', /json/)
+      .expect(403)
+  })
+})
+
+// 全文搜索需要在 config.json 中开启 contentIndex，并借助只读用户验证用户 root 也会被索引
+describe('全文搜索', () => {
+  let userRoot = ''
+  const filename = 'content-search.txt'
+  const token = `zqx${Date.now()}`
+
+  before(async function () {
+    if (!testConfig.contentIndex || !testUserToken) {
+      this.skip()
+    }
+    const response = await api.get('/api/files/drives')
+      .set('Authorization', testUserToken)
+      .expect(200)
+    userRoot = response.body[0].path
+    fs.writeFileSync(path.join(userRoot, filename), `first line\nfind ${token}suffix here\n`)
+  })
+
+  after(() => {
+    if (userRoot) {
+      fs.rmSync(path.join(userRoot, filename), { force: true })
+    }
+  })
+
+  const search = (q: string) => api.get('/api/files/content-search')
+    .set('Authorization', testUserToken)
+    .query({ q })
+    .expect('Content-Type', /json/)
+    .expect(200)
+
+  it('索引用户 root 中的文件', async function () {
+    this.timeout(15000)
+    await api.post('/api/files/content-search/refresh')
+      .set('Authorization', testUserToken)
+      .expect(202)
+    const deadline = Date.now() + 10000
+    let results: any[] = []
+    while (Date.now() < deadline) {
+      results = (await search(`${token}*`)).body.results
+      if (results.length > 0) {
+        break
+      }
+      await new Promise(resolve => setTimeout(resolve, 200))
+    }
+    expect(results.map(r => r.path)).to.include(path.join(userRoot, filename))
+    const hit = results.find(r => r.name === filename)
+    expect(hit.lines[0]).to.deep.equal({ line: 2, text: `find ${token}suffix here` })
+  })
+
+  it('整词匹配与前缀匹配', async () => {
+    expect((await search(token)).body.results).to.be.empty
+    expect((await search(`${token}suffix`)).body.results).to.have.lengthOf(1)
+    expect((await search(`${token}* here`)).body.results).to.have.lengthOf(1)
+    expect((await search(`${token}* missing`)).body.results).to.be.empty
+  })
+
+  it('查询过短时返回 400', async () => {
+    await api.get('/api/files/content-search')
+      .set('Authorization', testUserToken)
+      .query({ q: 'a' })
+      .expect(400)
+  })
+})
+
+describe('WebDAV', () => {
+  const prefix = testConfig.webdavPrefix as string
+  // 管理员用户名任意、密码为 token；普通用户的 token 即 用户名:密码
+  const basic = (credentials: string) => 'Basic ' + Buffer.from(credentials).toString('base64')
+  const adminAuth = basic(`any:${testConfig.password}`)
+  // PROPFIND、LOCK 等扩展方法
+  const dav = (method: string, p: string) => (api as any)[method.toLowerCase()](prefix + p)
+  const lockBody = '<?xml version="1.0"?><D:lockinfo xmlns:D="DAV:"><D:lockscope><D:exclusive/></D:lockscope><D:locktype><D:write/></D:locktype></D:lockinfo>'
+  const filename = 'webdav-test.txt'
+
+  before(function () {
+    if (!prefix) {
+      this.skip()
+    }
+  })
+
+  it('没有凭据时返回 401', async () => {
+    await dav('PROPFIND', '/').set('Depth', '0').expect(401)
+  })
+
+  it(`管理员上传、读取并删除：${filename}`, async () => {
+    await api.put(`${prefix}/${filename}`)
+      .set('Authorization', adminAuth)
+      .send('hello webdav')
+      .expect(201)
+    const response = await api.get(`${prefix}/${filename}`)
+      .set('Authorization', adminAuth)
+      .expect(200)
+    expect(response.text).to.equal('hello webdav')
+    await api.delete(`${prefix}/${filename}`)
+      .set('Authorization', adminAuth)
+      .expect(204)
+  })
+
+  describe('沙箱', () => {
+    // 测试目录在 WebDAV 根目录（safeBaseDir）下的相对路径
+    const davRoot = path.resolve(backendPath, testConfig.safeBaseDir || '/')
+    const localDir = path.resolve(backendPath, 'file-lite', 'webdav-sandbox')
+    const davDir = '/' + path.relative(davRoot, localDir).split(path.sep).join('/')
+
+    before(function () {
+      if (davDir.startsWith('/..')) {
+        this.skip()
+      }
+      fs.rmSync(localDir, { recursive: true, force: true })
+      fs.mkdirSync(localDir, { recursive: true })
+    })
+    after(() => {
+      fs.rmSync(localDir, { recursive: true, force: true })
+    })
+
+    it('PROPFIND 列出目录', async () => {
+      fs.writeFileSync(path.join(localDir, 'listed.txt'), 'listed')
+      const response = await dav('PROPFIND', davDir + '/')
+        .set('Authorization', adminAuth)
+        .set('Depth', '1')
+        .buffer(true)
+        .parse((res: any, callback: any) => {
+          let text = ''
+          res.on('data', (chunk: any) => { text += chunk })
+          res.on('end', () => callback(null, text))
+        })
+        .expect(207)
+      expect(response.body).to.contain('listed.txt')
+    })
+
+    it('PUT 后 MOVE', async () => {
+      await api.put(`${prefix}${davDir}/a.txt`)
+        .set('Authorization', adminAuth)
+        .send('move me')
+        .expect(201)
+      await dav('MOVE', `${davDir}/a.txt`)
+        .set('Authorization', adminAuth)
+        .set('Destination', `${prefix}${davDir}/b.txt`)
+        .expect(201)
+      expect(fs.existsSync(path.join(localDir, 'a.txt'))).to.equal(false)
+      expect(fs.readFileSync(path.join(localDir, 'b.txt'), 'utf-8')).to.equal('move me')
+    })
+
+    it('MOVE 的目标不能在挂载路径之外', async () => {
+      const response = await dav('MOVE', `${davDir}/b.txt`)
+        .set('Authorization', adminAuth)
+        .set('Destination', `/escaped-${Date.now()}.txt`)
+      expect(response.status).to.be.at.least(400)
+      expect(fs.existsSync(path.join(localDir, 'b.txt'))).to.equal(true)
+    })
+
+    it('路径中的 .. 不能离开根目录', async () => {
+      const escape = '/..%2f'.repeat(davDir.split('/').length + 8)
+      const response = await api.get(`${prefix}${davDir}${escape}etc/hostname`)
+        .set('Authorization', adminAuth)
+      expect(response.status).to.equal(404)
+    })
+
+    it('不跟随指向沙箱之外的符号链接', async function () {
+      if ((testConfig.symlinkPolicy || 'follow-within-sandbox') === 'follow' || process.platform === 'win32') {
+        this.skip()
+      }
+      fs.symlinkSync('/', path.join(localDir, 'outside'))
+      const response = await api.get(`${prefix}${davDir}/outside/etc/hostname`)
+        .set('Authorization', adminAuth)
+      expect(response.status).to.be.at.least(400)
+      const response2 = await dav('PROPFIND', `${davDir}/outside/`)
+        .set('Authorization', adminAuth)
+        .set('Depth', '1')
+      expect(response2.status).to.be.at.least(400)
+    })
+  })
+
+  describe('只读用户', () => {
+    before(function () {
+      if (!testUserToken) {
+        this.skip()
+      }
+    })
+
+    it('PROPFIND 需要 read', async () => {
+      await dav('PROPFIND', '/')
+        .set('Authorization', basic(testUserToken))
+        .set('Depth', '1')
+        .expect(207)
+    })
+
+    it('PUT、MKCOL、DELETE 被拒绝', async () => {
+      await api.put(`${prefix}/${filename}`)
+        .set('Authorization', basic(testUserToken))
+        .send('denied')
+        .expect(403)
+      await dav('MKCOL', '/webdav-denied')
+        .set('Authorization', basic(testUserToken))
+        .expect(403)
+      await api.delete(`${prefix}/${filename}`)
+        .set('Authorization', basic(testUserToken))
+        .expect(403)
+    })
+
+    it('LOCK 不存在的文件被拒绝，也不会创建文件', async () => {
+      await dav('LOCK', `/${filename}`)
+        .set('Authorization', basic(testUserToken))
+        .set('Content-Type', 'application/xml')
+        .send(lockBody)
+        .expect(403)
+      await dav('PROPFIND', `/${filename}`)
+        .set('Authorization', basic(testUserToken))
+        .set('Depth', '0')
+        .expect(404)
+    })
+  })
 })